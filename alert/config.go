@@ -0,0 +1,148 @@
+package alert
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the YAML alerting configuration:
+//
+//	state_file: /var/lib/powerwall/alerts.json
+//	notifiers:
+//	  ops:
+//	    type: webhook
+//	    url: https://hooks.example.com/powerwall
+//	  email:
+//	    type: smtp
+//	    addr: smtp.example.com:587
+//	    from: powerwall@example.com
+//	    to: [me@example.com]
+//	    username: powerwall@example.com
+//	    password_env: SMTP_PASSWORD
+//	  pager:
+//	    type: exec
+//	    command: [/usr/local/bin/page-oncall]
+//	rules:
+//	  - name: grid_down
+//	    field: grid_status
+//	    op: "!="
+//	    value: Active
+//	    for: 1m
+//	  - name: islanded
+//	    field: island_status
+//	    op: "!="
+//	    value: on_grid
+//	    notify: [pager]
+//	  - name: below_reserve
+//	    field: percentage_charged
+//	    op: "<"
+//	    compare_to: backup_reserve_percent
+//	    hysteresis: 2
+//	    resolve_for: 10m
+type Config struct {
+	StateFile string                    `yaml:"state_file"`
+	Notifiers map[string]NotifierConfig `yaml:"notifiers"`
+	Rules     []Rule                    `yaml:"rules"`
+}
+
+// NotifierConfig configures one notifier.  Type is "webhook", "smtp" or
+// "exec", and determines which of the other fields are used.  Secrets can be
+// read from the environment with the *_env fields instead of being written
+// into the file.
+type NotifierConfig struct {
+	Type string `yaml:"type"`
+
+	// webhook
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+
+	// smtp
+	Addr        string   `yaml:"addr"`
+	From        string   `yaml:"from"`
+	To          []string `yaml:"to"`
+	Username    string   `yaml:"username"`
+	Password    string   `yaml:"password"`
+	PasswordEnv string   `yaml:"password_env"`
+
+	// exec
+	Command []string      `yaml:"command"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// LoadConfig reads a YAML alerting configuration from path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// NewFromConfig creates the configured notifiers and an Engine for the
+// configured rules.  The state file from the config is used unless options
+// override it.
+func NewFromConfig(config *Config, options ...func(e *Engine)) (*Engine, error) {
+	notifiers := make(map[string]Notifier, len(config.Notifiers))
+	for name, nc := range config.Notifiers {
+		notifier, err := nc.notifier()
+		if err != nil {
+			return nil, fmt.Errorf("notifier %s: %w", name, err)
+		}
+		notifiers[name] = notifier
+	}
+
+	if config.StateFile != "" {
+		options = append([]func(e *Engine){WithStateFile(config.StateFile)}, options...)
+	}
+	return New(config.Rules, notifiers, options...)
+}
+
+// notifier creates the Notifier described by the config.
+func (nc NotifierConfig) notifier() (Notifier, error) {
+	switch nc.Type {
+	case "webhook":
+		if nc.URL == "" {
+			return nil, fmt.Errorf("webhook requires a url")
+		}
+		var options []func(w *WebhookNotifier)
+		for key, value := range nc.Headers {
+			options = append(options, WithWebhookHeader(key, value))
+		}
+		return NewWebhookNotifier(nc.URL, options...), nil
+
+	case "smtp":
+		if nc.Addr == "" || nc.From == "" || len(nc.To) == 0 {
+			return nil, fmt.Errorf("smtp requires addr, from and to")
+		}
+		password := nc.Password
+		if nc.PasswordEnv != "" {
+			password = os.Getenv(nc.PasswordEnv)
+		}
+		var options []func(s *SMTPNotifier)
+		if nc.Username != "" {
+			options = append(options, WithSMTPAuth(nc.Username, password))
+		}
+		return NewSMTPNotifier(nc.Addr, nc.From, nc.To, options...), nil
+
+	case "exec":
+		if len(nc.Command) == 0 {
+			return nil, fmt.Errorf("exec requires a command")
+		}
+		var options []func(e *ExecNotifier)
+		if nc.Timeout > 0 {
+			options = append(options, WithExecTimeout(nc.Timeout))
+		}
+		return NewExecNotifier(nc.Command, options...), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported notifier type %q (supported: webhook, smtp, exec)", nc.Type)
+	}
+}
@@ -0,0 +1,245 @@
+// Package alert evaluates rules against polled Powerwall snapshots and sends
+// notifications when they fire and resolve.
+//
+// An Engine is a metrics.Sink, so it is driven by a metrics.Poller like the
+// other exporters.  Each rule compares a live_status field (or the backup
+// reserve) against a value or another field, with optional debounce (For,
+// ResolveFor) and hysteresis.  Rule state is kept per energy site and can be
+// persisted to a file so that a restart doesn't re-fire alerts which are
+// already firing:
+//
+//	engine, err := alert.New([]alert.Rule{
+//		{Name: "grid_down", Field: "grid_status", Op: "!=", Value: "Active", For: time.Minute},
+//		{Name: "below_reserve", Field: "percentage_charged", Op: "<", CompareTo: "backup_reserve_percent", Hysteresis: 2},
+//	}, map[string]alert.Notifier{"ops": alert.NewWebhookNotifier(url)},
+//		alert.WithStateFile("alerts.json"))
+//
+//	poller := metrics.NewPoller([]metrics.Sink{engine})
+//	poller.Run(ctx, client)
+//
+// Rules and notifiers can also be loaded from YAML with LoadConfig.
+package alert
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/blampe/powerwall"
+	"github.com/blampe/powerwall/internal/jsonfile"
+	"github.com/blampe/powerwall/metrics"
+)
+
+// Engine evaluates rules against snapshots and dispatches notifications.
+type Engine struct {
+	rules      []Rule
+	notifiers  map[string]Notifier
+	stateFile  string
+	errHandler func(error)
+
+	mu    sync.Mutex
+	state map[string]*ruleState
+}
+
+// ruleState is the persisted state of one rule for one energy site.
+type ruleState struct {
+	Firing bool `json:"firing"`
+
+	// Since is when the condition first disagreed with Firing, i.e. when a
+	// pending transition started.  It is zero when nothing is pending.
+	Since time.Time `json:"since"`
+
+	FiredAt time.Time `json:"fired_at"`
+	Value   string    `json:"value,omitempty"`
+}
+
+// New creates an Engine for the given rules.  Rules refer to notifiers by
+// their key in the notifiers map.
+func New(rules []Rule, notifiers map[string]Notifier, options ...func(e *Engine)) (*Engine, error) {
+	e := &Engine{
+		rules:      rules,
+		notifiers:  notifiers,
+		errHandler: func(error) {},
+		state:      map[string]*ruleState{},
+	}
+
+	// Apply options
+	for _, option := range options {
+		if option != nil {
+			option(e)
+		}
+	}
+
+	names := map[string]bool{}
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate alert rule name: %s", rule.Name)
+		}
+		names[rule.Name] = true
+		for _, name := range rule.Notify {
+			if _, ok := notifiers[name]; !ok {
+				return nil, fmt.Errorf("alert rule %s: unknown notifier %q", rule.Name, name)
+			}
+		}
+	}
+
+	if e.stateFile != "" {
+		// Restore state so that alerts already firing aren't re-sent
+		if err := jsonfile.Read(e.stateFile, &e.state); err != nil {
+			return nil, fmt.Errorf("reading alert state %s: %w", e.stateFile, err)
+		}
+	}
+
+	return e, nil
+}
+
+// WithStateFile persists rule state to path, and restores it on startup
+func WithStateFile(path string) func(e *Engine) {
+	return func(e *Engine) {
+		e.stateFile = path
+	}
+}
+
+// WithErrorHandler receives failed notifications, naming the rule and
+// notifier, and failures to save the state file
+func WithErrorHandler(f func(error)) func(e *Engine) {
+	return func(e *Engine) {
+		e.errHandler = f
+	}
+}
+
+// WriteSnapshot evaluates every rule against the snapshot.  A failed
+// notification is reported to the error handler but doesn't undo the state
+// change, so it isn't retried.
+func (e *Engine) WriteSnapshot(ctx context.Context, snapshot metrics.Snapshot) error {
+	fields, err := snapshotFields(snapshot)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	e.mu.Lock()
+	var notifications []pending
+	changed := false
+	for _, rule := range e.rules {
+		key := stateKey(snapshot.Site.ID, rule.Name)
+		st, ok := e.state[key]
+		if !ok {
+			st = &ruleState{}
+			e.state[key] = st
+		}
+
+		active, value, ok := rule.evaluate(fields, st.Firing)
+		if !ok {
+			continue
+		}
+		if active == st.Firing {
+			if !st.Since.IsZero() {
+				st.Since = time.Time{}
+				changed = true
+			}
+			continue
+		}
+
+		if st.Since.IsZero() {
+			st.Since = now
+			changed = true
+		}
+		wait := rule.For
+		if st.Firing {
+			wait = rule.ResolveFor
+		}
+		if now.Sub(st.Since) < wait {
+			continue
+		}
+
+		st.Firing = active
+		st.Since = time.Time{}
+		st.Value = value
+		if active {
+			st.FiredAt = now
+		}
+		changed = true
+
+		notifications = append(notifications, pending{rule, newNotification(rule, snapshot.Site, !active, value, now)})
+	}
+	if changed && e.stateFile != "" {
+		if err := jsonfile.Write(e.stateFile, e.state); err != nil {
+			e.errHandler(fmt.Errorf("saving alert state: %w", err))
+		}
+	}
+	e.mu.Unlock()
+
+	for _, p := range notifications {
+		e.notify(ctx, p.rule, p.notification)
+	}
+	return nil
+}
+
+// WriteHistory does nothing; rules are only evaluated against live snapshots.
+func (e *Engine) WriteHistory(ctx context.Context, site metrics.Site, history *powerwall.HistoryData) error {
+	return nil
+}
+
+// Firing returns the names of the rules currently firing for an energy site.
+func (e *Engine) Firing(siteID int64) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var names []string
+	for _, rule := range e.rules {
+		if st, ok := e.state[stateKey(siteID, rule.Name)]; ok && st.Firing {
+			names = append(names, rule.Name)
+		}
+	}
+	return names
+}
+
+// pending is a notification waiting to be sent once the state lock is released.
+type pending struct {
+	rule         Rule
+	notification Notification
+}
+
+// notify sends a notification to each of the rule's notifiers, or to every
+// notifier if the rule doesn't name any.
+func (e *Engine) notify(ctx context.Context, rule Rule, n Notification) {
+	names := rule.Notify
+	if len(names) == 0 {
+		for name := range e.notifiers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		if err := e.notifiers[name].Notify(ctx, n); err != nil {
+			e.errHandler(fmt.Errorf("alert %s: notifier %s: %w", rule.Name, name, err))
+		}
+	}
+}
+
+func newNotification(rule Rule, site metrics.Site, resolved bool, value string, now time.Time) Notification {
+	message := fmt.Sprintf("%s: %s is %s (alert when %s)", site.Name, rule.Field, value, rule)
+	if resolved {
+		message = fmt.Sprintf("%s: %s is %s, resolved (alert when %s)", site.Name, rule.Field, value, rule)
+	}
+	return Notification{
+		Rule:     rule.Name,
+		SiteID:   site.ID,
+		SiteName: site.Name,
+		Resolved: resolved,
+		Value:    value,
+		Time:     now,
+		Message:  message,
+	}
+}
+
+func stateKey(siteID int64, rule string) string {
+	return fmt.Sprintf("%d/%s", siteID, rule)
+}
@@ -0,0 +1,248 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notification is sent when a rule starts firing or resolves.
+type Notification struct {
+	Rule     string    `json:"rule"`
+	SiteID   int64     `json:"site_id"`
+	SiteName string    `json:"site_name"`
+	Resolved bool      `json:"resolved"`
+	Value    string    `json:"value"`
+	Time     time.Time `json:"time"`
+	Message  string    `json:"message"`
+}
+
+// Subject returns a one-line summary suitable for an email subject.
+func (n Notification) Subject() string {
+	state := "FIRING"
+	if n.Resolved {
+		state = "RESOLVED"
+	}
+	return fmt.Sprintf("[%s] %s (%s)", state, n.Rule, n.SiteName)
+}
+
+// Notifier delivers notifications.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Webhook
+
+// WebhookNotifier POSTs each notification as JSON to a URL.
+type WebhookNotifier struct {
+	url        string
+	headers    map[string]string
+	httpClient *http.Client
+}
+
+// NewWebhookNotifier creates a notifier which POSTs to url.
+func NewWebhookNotifier(url string, options ...func(w *WebhookNotifier)) *WebhookNotifier {
+	w := &WebhookNotifier{
+		url:        url,
+		headers:    map[string]string{},
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+
+	// Apply options
+	for _, option := range options {
+		if option != nil {
+			option(w)
+		}
+	}
+
+	return w
+}
+
+// WithWebhookHeader adds a header to every webhook request
+func WithWebhookHeader(key, value string) func(w *WebhookNotifier) {
+	return func(w *WebhookNotifier) {
+		w.headers[key] = value
+	}
+}
+
+// WithWebhookHttpClient sets the HTTP client used for webhook requests
+func WithWebhookHttpClient(httpClient *http.Client) func(w *WebhookNotifier) {
+	return func(w *WebhookNotifier) {
+		w.httpClient = httpClient
+	}
+}
+
+// Notify POSTs the notification to the webhook URL.
+func (w *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range w.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("webhook returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// SMTP
+
+// SMTPNotifier sends each notification as a plain-text email.
+type SMTPNotifier struct {
+	addr     string
+	from     string
+	to       []string
+	username string
+	password string
+}
+
+// NewSMTPNotifier creates a notifier which sends mail through the SMTP server
+// at addr ("host:port").  STARTTLS is used when the server supports it.
+func NewSMTPNotifier(addr, from string, to []string, options ...func(s *SMTPNotifier)) *SMTPNotifier {
+	s := &SMTPNotifier{
+		addr: addr,
+		from: from,
+		to:   to,
+	}
+
+	// Apply options
+	for _, option := range options {
+		if option != nil {
+			option(s)
+		}
+	}
+
+	return s
+}
+
+// WithSMTPAuth sets the credentials used for PLAIN authentication
+func WithSMTPAuth(username, password string) func(s *SMTPNotifier) {
+	return func(s *SMTPNotifier) {
+		s.username = username
+		s.password = password
+	}
+}
+
+// Notify sends the notification by email.  smtp.SendMail doesn't take a
+// context, so ctx is not honoured once the connection is made.
+func (s *SMTPNotifier) Notify(ctx context.Context, n Notification) error {
+	if len(s.to) == 0 {
+		return fmt.Errorf("no email recipients configured")
+	}
+
+	var auth smtp.Auth
+	if s.username != "" {
+		host, _, err := net.SplitHostPort(s.addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.username, s.password, host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", n.Subject())
+	fmt.Fprintf(&msg, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n", n.Message)
+
+	return smtp.SendMail(s.addr, auth, s.from, s.to, msg.Bytes())
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Exec
+
+// ExecNotifier runs a command for each notification.  The notification is
+// written to the command's stdin as JSON, and its fields are also available
+// in the POWERWALL_ALERT_* environment variables.
+type ExecNotifier struct {
+	command []string
+	timeout time.Duration
+}
+
+// NewExecNotifier creates a notifier which runs command (program followed by
+// its arguments).
+func NewExecNotifier(command []string, options ...func(e *ExecNotifier)) *ExecNotifier {
+	e := &ExecNotifier{
+		command: command,
+		timeout: 30 * time.Second,
+	}
+
+	// Apply options
+	for _, option := range options {
+		if option != nil {
+			option(e)
+		}
+	}
+
+	return e
+}
+
+// WithExecTimeout sets how long the command may run before it is killed
+func WithExecTimeout(timeout time.Duration) func(e *ExecNotifier) {
+	return func(e *ExecNotifier) {
+		e.timeout = timeout
+	}
+}
+
+// Notify runs the command and waits for it to exit.
+func (e *ExecNotifier) Notify(ctx context.Context, n Notification) error {
+	if len(e.command) == 0 {
+		return fmt.Errorf("no command configured")
+	}
+
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, e.command[0], e.command[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"POWERWALL_ALERT_RULE="+n.Rule,
+		"POWERWALL_ALERT_SITE_ID="+strconv.FormatInt(n.SiteID, 10),
+		"POWERWALL_ALERT_SITE_NAME="+n.SiteName,
+		"POWERWALL_ALERT_RESOLVED="+strconv.FormatBool(n.Resolved),
+		"POWERWALL_ALERT_VALUE="+n.Value,
+		"POWERWALL_ALERT_TIME="+n.Time.Format(time.RFC3339),
+		"POWERWALL_ALERT_MESSAGE="+n.Message,
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", e.command[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
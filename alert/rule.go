@@ -0,0 +1,183 @@
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/blampe/powerwall/metrics"
+)
+
+// Comparison operators supported by Rule.Op.
+const (
+	OpEqual          = "=="
+	OpNotEqual       = "!="
+	OpLess           = "<"
+	OpLessOrEqual    = "<="
+	OpGreater        = ">"
+	OpGreaterOrEqual = ">="
+)
+
+// BackupReserveField is the name of the backup reserve percentage, which is
+// available to rules alongside the live_status fields.
+const BackupReserveField = "backup_reserve_percent"
+
+// Rule is a condition evaluated against every polled snapshot.
+//
+// Field names a live_status field by its JSON key (e.g. "grid_status",
+// "percentage_charged") or BackupReserveField.  The field is compared with Op
+// to either the literal Value or, if CompareTo is set, to another field.
+// Equality operators compare strings; the ordering operators compare numbers.
+type Rule struct {
+	Name      string `yaml:"name"`
+	Field     string `yaml:"field"`
+	Op        string `yaml:"op"`
+	Value     string `yaml:"value"`
+	CompareTo string `yaml:"compare_to"`
+
+	// For is how long the condition must hold before the rule fires.
+	For time.Duration `yaml:"for"`
+
+	// ResolveFor is how long the condition must be clear before a firing rule
+	// resolves.
+	ResolveFor time.Duration `yaml:"resolve_for"`
+
+	// Hysteresis is the margin by which a numeric value must move back past
+	// the threshold before the condition is considered clear.  For example a
+	// "percentage_charged < 20" rule with a hysteresis of 5 stays firing until
+	// the charge reaches 25%.
+	Hysteresis float64 `yaml:"hysteresis"`
+
+	// Notify lists the names of the notifiers to send to.  If empty, every
+	// notifier is used.
+	Notify []string `yaml:"notify"`
+}
+
+// validate checks that the rule is well formed.
+func (r Rule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("alert rule is missing a name")
+	}
+	if r.Field == "" {
+		return fmt.Errorf("alert rule %s: missing field", r.Name)
+	}
+	switch r.Op {
+	case OpEqual, OpNotEqual:
+	case OpLess, OpLessOrEqual, OpGreater, OpGreaterOrEqual:
+		if r.CompareTo == "" {
+			if _, err := strconv.ParseFloat(r.Value, 64); err != nil {
+				return fmt.Errorf("alert rule %s: %s requires a numeric value, got %q", r.Name, r.Op, r.Value)
+			}
+		}
+	default:
+		return fmt.Errorf("alert rule %s: unsupported operator %q", r.Name, r.Op)
+	}
+	if r.Hysteresis < 0 {
+		return fmt.Errorf("alert rule %s: hysteresis must not be negative", r.Name)
+	}
+	return nil
+}
+
+// evaluate reports whether the rule's condition holds for the given snapshot
+// fields.  firing is the rule's current state, which widens the condition by
+// the hysteresis margin.  ok is false if a field the rule needs is missing, in
+// which case the rule's state should be left alone.  value is the current
+// value of the rule's field, for notifications.
+func (r Rule) evaluate(fields map[string]interface{}, firing bool) (active bool, value string, ok bool) {
+	actual, found := fields[r.Field]
+	if !found || actual == nil {
+		return false, "", false
+	}
+	value = formatValue(actual)
+
+	expected := interface{}(r.Value)
+	if r.CompareTo != "" {
+		expected, found = fields[r.CompareTo]
+		if !found || expected == nil {
+			return false, value, false
+		}
+	}
+
+	switch r.Op {
+	case OpEqual:
+		return value == formatValue(expected), value, true
+	case OpNotEqual:
+		return value != formatValue(expected), value, true
+	}
+
+	a, aok := toFloat(actual)
+	b, bok := toFloat(expected)
+	if !aok || !bok {
+		return false, value, false
+	}
+
+	// While firing, the threshold moves away from the value by the
+	// hysteresis margin so that small fluctuations don't resolve the alert.
+	margin := 0.0
+	if firing {
+		margin = r.Hysteresis
+	}
+	switch r.Op {
+	case OpLess:
+		return a < b+margin, value, true
+	case OpLessOrEqual:
+		return a <= b+margin, value, true
+	case OpGreater:
+		return a > b-margin, value, true
+	case OpGreaterOrEqual:
+		return a >= b-margin, value, true
+	}
+	return false, value, false
+}
+
+// String describes the rule's condition, e.g. "grid_status != Active".
+func (r Rule) String() string {
+	if r.CompareTo != "" {
+		return fmt.Sprintf("%s %s %s", r.Field, r.Op, r.CompareTo)
+	}
+	return fmt.Sprintf("%s %s %s", r.Field, r.Op, r.Value)
+}
+
+// snapshotFields returns every scalar live_status field of the snapshot keyed
+// by its JSON name, plus the backup reserve.  Fields the gateway didn't report
+// are nil.
+func snapshotFields(snapshot metrics.Snapshot) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if snapshot.LiveStatus != nil {
+		data, err := json.Marshal(snapshot.LiveStatus)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+	}
+	fields[BackupReserveField] = float64(snapshot.BackupReserve)
+	return fields, nil
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
@@ -1,8 +1,15 @@
 // Fleet API implementation of all Powerwall methods
 //
 // Core API methods:
+//	(*Client) GetLiveStatus() - Raw live_status data
 //	(*Client) GetStatus() - Enhanced real-time data
 //	(*Client) GetSiteInfo() - Site configuration
+//	(*Client) GetSiteConfig() - Full site_info configuration
+//	(*Client) GetFirmwareVersions() - Site and per-gateway firmware versions
+//	(*Client) GetBackupReserve() - Current backup reserve percentage
+//	(*Client) GetOperationMode() - Current operation mode
+//	(*Client) GetStormMode() - Whether Storm Watch is enabled
+//	(*Client) GetTariffContent() - Utility rate plan from site_info
 //	(*Client) GetMetersAggregates() - Power flow data
 //	(*Client) GetSOE() - Battery state of energy
 //	(*Client) GetGridStatus() - Grid connection status
@@ -15,19 +22,29 @@
 // Historical data (WORKING):
 //	(*Client) GetEnergyHistory() - Energy data via calendar_history endpoint
 //	(*Client) GetBackupHistory() - Backup events via calendar_history endpoint
+//	(*Client) GetPowerHistory() - Power samples via calendar_history endpoint
 //	(*Client) GetTelemetryHistory() - Telemetry data via telemetry_history endpoint
 //	(*Client) GetCalendarHistory() - Generic calendar_history endpoint
 //
 // Control commands (WORKING):
 //	(*Client) SetBackupReserve() - Set backup percentage
 //	(*Client) SetStormMode() - Enable/disable Storm Watch
+//	(*Client) SetOperationMode() - Self-powered / time-based control / backup-only
 //	(*Client) SetSiteName() - Rename site
+//	(*Client) SetExportRule() - Which energy may be exported to the grid
+//	(*Client) SetGridCharging() - Allow/disallow charging from the grid
+//
+// Each of these methods, except SelectEnergySite, has a ...Context variant
+// (e.g. GetLiveStatusContext(ctx)) which uses ctx for cancellation and
+// tracing.
 
 package powerwall
 
 import (
+	"context"
 	"fmt"
 	"net/url"
+	"strconv"
 	"time"
 )
 
@@ -36,10 +53,15 @@ import (
 
 // GetProducts returns all products (vehicles + energy sites) associated with the account
 func (c *Client) GetProducts() ([]EnergyProduct, error) {
+	return c.GetProductsContext(context.Background())
+}
+
+// GetProductsContext is like GetProducts but uses ctx for cancellation and tracing.
+func (c *Client) GetProductsContext(ctx context.Context) ([]EnergyProduct, error) {
 	c.logf("Fetching all products...")
 
 	var resp ProductsResponse
-	err := c.apiGetJson("/api/1/products", &resp)
+	err := c.apiGetJson(ctx, "/api/1/products", &resp)
 	if err != nil {
 		return nil, err
 	}
@@ -50,7 +72,12 @@ func (c *Client) GetProducts() ([]EnergyProduct, error) {
 
 // GetEnergyProducts returns only energy sites (filtering out vehicles)
 func (c *Client) GetEnergyProducts() ([]EnergyProduct, error) {
-	products, err := c.GetProducts()
+	return c.GetEnergyProductsContext(context.Background())
+}
+
+// GetEnergyProductsContext is like GetEnergyProducts but uses ctx for cancellation and tracing.
+func (c *Client) GetEnergyProductsContext(ctx context.Context) ([]EnergyProduct, error) {
+	products, err := c.GetProductsContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -87,12 +114,48 @@ func (c *Client) ensureSiteSelected() error {
 	return nil
 }
 
+///////////////////////////////////////////////////////////////////////////////
+// Live Status API
+
+// GetLiveStatus returns the unmodified real-time data from the Fleet API
+// live_status endpoint for the selected energy site.
+func (c *Client) GetLiveStatus() (*LiveStatus, error) {
+	return c.GetLiveStatusContext(context.Background())
+}
+
+// GetLiveStatusContext is like GetLiveStatus but uses ctx for cancellation and tracing.
+func (c *Client) GetLiveStatusContext(ctx context.Context) (*LiveStatus, error) {
+	if err := c.ensureSiteSelected(); err != nil {
+		return nil, err
+	}
+
+	c.logf("Fetching live status for energy site %d...", c.selectedSiteID)
+
+	var liveStatus LiveStatusResponse
+	endpoint := fmt.Sprintf("/api/1/energy_sites/%d/live_status", c.selectedSiteID)
+	err := c.apiGetJson(ctx, endpoint, &liveStatus)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkFreshness(&liveStatus.Response); err != nil {
+		return nil, err
+	}
+
+	c.logf("Live status retrieved successfully: timestamp=%s", liveStatus.Response.Timestamp.Format(time.RFC3339))
+	return &liveStatus.Response, nil
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 // Status API - Enhanced with Fleet API live_status data
 
 // GetStatus returns enhanced system status from Fleet API live_status endpoint.
 // This provides much richer real-time data than the local gateway status endpoint.
 func (c *Client) GetStatus() (*StatusData, error) {
+	return c.GetStatusContext(context.Background())
+}
+
+// GetStatusContext is like GetStatus but uses ctx for cancellation and tracing.
+func (c *Client) GetStatusContext(ctx context.Context) (*StatusData, error) {
 	if err := c.ensureSiteSelected(); err != nil {
 		return nil, err
 	}
@@ -101,10 +164,13 @@ func (c *Client) GetStatus() (*StatusData, error) {
 
 	var liveStatus LiveStatusResponse
 	endpoint := fmt.Sprintf("/api/1/energy_sites/%d/live_status", c.selectedSiteID)
-	err := c.apiGetJson(endpoint, &liveStatus)
+	err := c.apiGetJson(ctx, endpoint, &liveStatus)
 	if err != nil {
 		return nil, err
 	}
+	if err := c.checkFreshness(&liveStatus.Response); err != nil {
+		return nil, err
+	}
 
 	// Map Fleet API live_status to StatusData structure
 	// Note: Many fields from local gateway are not available via Fleet API
@@ -133,6 +199,11 @@ func (c *Client) GetStatus() (*StatusData, error) {
 // GetSiteInfo returns enhanced site information from Fleet API site_info endpoint.
 // This provides installation details and configuration not available from local gateway.
 func (c *Client) GetSiteInfo() (*SiteInfoData, error) {
+	return c.GetSiteInfoContext(context.Background())
+}
+
+// GetSiteInfoContext is like GetSiteInfo but uses ctx for cancellation and tracing.
+func (c *Client) GetSiteInfoContext(ctx context.Context) (*SiteInfoData, error) {
 	if err := c.ensureSiteSelected(); err != nil {
 		return nil, err
 	}
@@ -141,7 +212,7 @@ func (c *Client) GetSiteInfo() (*SiteInfoData, error) {
 
 	var siteInfo SiteInfoResponse
 	endpoint := fmt.Sprintf("/api/1/energy_sites/%d/site_info", c.selectedSiteID)
-	err := c.apiGetJson(endpoint, &siteInfo)
+	err := c.apiGetJson(ctx, endpoint, &siteInfo)
 	if err != nil {
 		return nil, err
 	}
@@ -179,12 +250,172 @@ func (c *Client) GetSiteInfo() (*SiteInfoData, error) {
 	return info, nil
 }
 
+// GetSiteConfig returns the full configuration of the energy site from the
+// Fleet API site_info endpoint, including installed components, gateways and
+// their firmware versions, battery count and reserve settings.
+func (c *Client) GetSiteConfig() (*SiteConfig, error) {
+	return c.GetSiteConfigContext(context.Background())
+}
+
+// GetSiteConfigContext is like GetSiteConfig but uses ctx for cancellation and tracing.
+func (c *Client) GetSiteConfigContext(ctx context.Context) (*SiteConfig, error) {
+	if err := c.ensureSiteSelected(); err != nil {
+		return nil, err
+	}
+
+	c.logf("Fetching site config for energy site %d...", c.selectedSiteID)
+
+	var siteInfo SiteInfoResponse
+	endpoint := fmt.Sprintf("/api/1/energy_sites/%d/site_info", c.selectedSiteID)
+	err := c.apiGetJson(ctx, endpoint, &siteInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	c.logf("Site config retrieved successfully: %s", siteInfo.Response.SiteName)
+	return &siteInfo.Response, nil
+}
+
+// GetFirmwareVersions returns the software version of the energy site and the
+// firmware version of each of its gateways, from the Fleet API site_info
+// endpoint.
+func (c *Client) GetFirmwareVersions() (*FirmwareVersions, error) {
+	return c.GetFirmwareVersionsContext(context.Background())
+}
+
+// GetFirmwareVersionsContext is like GetFirmwareVersions but uses ctx for cancellation and tracing.
+func (c *Client) GetFirmwareVersionsContext(ctx context.Context) (*FirmwareVersions, error) {
+	cfg, err := c.GetSiteConfigContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := &FirmwareVersions{Version: cfg.Version}
+	for _, g := range cfg.Components.Gateways {
+		versions.Gateways = append(versions.Gateways, GatewayFirmware{
+			DIN:             g.DIN,
+			SerialNumber:    g.SerialNumber,
+			PartName:        g.PartName,
+			FirmwareVersion: g.FirmwareVersion,
+			UpdatedDatetime: g.UpdatedDatetime,
+		})
+	}
+	return versions, nil
+}
+
+// GetBackupReserve returns the current battery backup reserve percentage from
+// the Fleet API site_info endpoint.
+func (c *Client) GetBackupReserve() (int, error) {
+	return c.GetBackupReserveContext(context.Background())
+}
+
+// GetBackupReserveContext is like GetBackupReserve but uses ctx for cancellation and tracing.
+func (c *Client) GetBackupReserveContext(ctx context.Context) (int, error) {
+	if err := c.ensureSiteSelected(); err != nil {
+		return 0, err
+	}
+
+	c.logf("Fetching backup reserve for energy site %d...", c.selectedSiteID)
+
+	var siteInfo SiteInfoResponse
+	endpoint := fmt.Sprintf("/api/1/energy_sites/%d/site_info", c.selectedSiteID)
+	err := c.apiGetJson(ctx, endpoint, &siteInfo)
+	if err != nil {
+		return 0, err
+	}
+
+	if siteInfo.Response.BackupReservePercent == nil {
+		return 0, fmt.Errorf("site_info for energy site %d did not include backup_reserve_percent", c.selectedSiteID)
+	}
+
+	c.logf("Backup reserve retrieved successfully: %d%%", *siteInfo.Response.BackupReservePercent)
+	return *siteInfo.Response.BackupReservePercent, nil
+}
+
+// GetOperationMode returns the current operation mode of the energy site
+// (one of the OperationMode* constants).
+func (c *Client) GetOperationMode() (string, error) {
+	return c.GetOperationModeContext(context.Background())
+}
+
+// GetOperationModeContext is like GetOperationMode but uses ctx for cancellation and tracing.
+func (c *Client) GetOperationModeContext(ctx context.Context) (string, error) {
+	if err := c.ensureSiteSelected(); err != nil {
+		return "", err
+	}
+
+	c.logf("Fetching operation mode for energy site %d...", c.selectedSiteID)
+
+	var siteInfo SiteInfoResponse
+	endpoint := fmt.Sprintf("/api/1/energy_sites/%d/site_info", c.selectedSiteID)
+	err := c.apiGetJson(ctx, endpoint, &siteInfo)
+	if err != nil {
+		return "", err
+	}
+
+	c.logf("Operation mode retrieved successfully: %s", siteInfo.Response.DefaultRealMode)
+	return siteInfo.Response.DefaultRealMode, nil
+}
+
+// GetStormMode returns whether Storm Watch is enabled for the energy site
+// (the storm_mode_enabled user setting of site_info).  Use LiveStatus
+// StormModeActive to see whether Storm Watch is currently preparing for a
+// storm.
+func (c *Client) GetStormMode() (bool, error) {
+	return c.GetStormModeContext(context.Background())
+}
+
+// GetStormModeContext is like GetStormMode but uses ctx for cancellation and tracing.
+func (c *Client) GetStormModeContext(ctx context.Context) (bool, error) {
+	cfg, err := c.GetSiteConfigContext(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	enabled, ok := cfg.UserSettings["storm_mode_enabled"].(bool)
+	if !ok {
+		return false, fmt.Errorf("site_info for energy site %d did not include storm_mode_enabled", c.selectedSiteID)
+	}
+	return enabled, nil
+}
+
+// GetTariffContent returns the site's utility rate plan (the tariff_content
+// field of site_info) in Tesla's tariff format.  It is nil if no rate plan
+// has been configured.
+func (c *Client) GetTariffContent() (map[string]interface{}, error) {
+	return c.GetTariffContentContext(context.Background())
+}
+
+// GetTariffContentContext is like GetTariffContent but uses ctx for cancellation and tracing.
+func (c *Client) GetTariffContentContext(ctx context.Context) (map[string]interface{}, error) {
+	if err := c.ensureSiteSelected(); err != nil {
+		return nil, err
+	}
+
+	c.logf("Fetching tariff for energy site %d...", c.selectedSiteID)
+
+	var siteInfo SiteInfoResponse
+	endpoint := fmt.Sprintf("/api/1/energy_sites/%d/site_info", c.selectedSiteID)
+	err := c.apiGetJson(ctx, endpoint, &siteInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	c.logf("Tariff retrieved successfully: %s", siteInfo.Response.TariffID)
+	return siteInfo.Response.TariffContent, nil
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 // Meters/Aggregates API - Power flow data from live_status
 
 // GetMetersAggregates returns real-time power flow data from Fleet API live_status.
 // This provides similar data to local gateway meters/aggregates but from cloud API.
 func (c *Client) GetMetersAggregates() (map[string]MeterAggregatesData, error) {
+	return c.GetMetersAggregatesContext(context.Background())
+}
+
+// GetMetersAggregatesContext is like GetMetersAggregates but uses ctx for cancellation and tracing.
+func (c *Client) GetMetersAggregatesContext(ctx context.Context) (map[string]MeterAggregatesData, error) {
 	if err := c.ensureSiteSelected(); err != nil {
 		return nil, err
 	}
@@ -193,10 +424,13 @@ func (c *Client) GetMetersAggregates() (map[string]MeterAggregatesData, error) {
 
 	var liveStatus LiveStatusResponse
 	endpoint := fmt.Sprintf("/api/1/energy_sites/%d/live_status", c.selectedSiteID)
-	err := c.apiGetJson(endpoint, &liveStatus)
+	err := c.apiGetJson(ctx, endpoint, &liveStatus)
 	if err != nil {
 		return nil, err
 	}
+	if err := c.checkFreshness(&liveStatus.Response); err != nil {
+		return nil, err
+	}
 
 	result := make(map[string]MeterAggregatesData)
 
@@ -241,6 +475,11 @@ func (c *Client) GetMetersAggregates() (map[string]MeterAggregatesData, error) {
 
 // GetSOE returns battery state of energy from Fleet API live_status.
 func (c *Client) GetSOE() (*SOEData, error) {
+	return c.GetSOEContext(context.Background())
+}
+
+// GetSOEContext is like GetSOE but uses ctx for cancellation and tracing.
+func (c *Client) GetSOEContext(ctx context.Context) (*SOEData, error) {
 	if err := c.ensureSiteSelected(); err != nil {
 		return nil, err
 	}
@@ -249,10 +488,13 @@ func (c *Client) GetSOE() (*SOEData, error) {
 
 	var liveStatus LiveStatusResponse
 	endpoint := fmt.Sprintf("/api/1/energy_sites/%d/live_status", c.selectedSiteID)
-	err := c.apiGetJson(endpoint, &liveStatus)
+	err := c.apiGetJson(ctx, endpoint, &liveStatus)
 	if err != nil {
 		return nil, err
 	}
+	if err := c.checkFreshness(&liveStatus.Response); err != nil {
+		return nil, err
+	}
 
 	soe := &SOEData{
 		Percentage: 0,
@@ -271,6 +513,11 @@ func (c *Client) GetSOE() (*SOEData, error) {
 
 // GetGridStatus returns grid connection status from Fleet API live_status.
 func (c *Client) GetGridStatus() (*GridStatusData, error) {
+	return c.GetGridStatusContext(context.Background())
+}
+
+// GetGridStatusContext is like GetGridStatus but uses ctx for cancellation and tracing.
+func (c *Client) GetGridStatusContext(ctx context.Context) (*GridStatusData, error) {
 	if err := c.ensureSiteSelected(); err != nil {
 		return nil, err
 	}
@@ -279,10 +526,13 @@ func (c *Client) GetGridStatus() (*GridStatusData, error) {
 
 	var liveStatus LiveStatusResponse
 	endpoint := fmt.Sprintf("/api/1/energy_sites/%d/live_status", c.selectedSiteID)
-	err := c.apiGetJson(endpoint, &liveStatus)
+	err := c.apiGetJson(ctx, endpoint, &liveStatus)
 	if err != nil {
 		return nil, err
 	}
+	if err := c.checkFreshness(&liveStatus.Response); err != nil {
+		return nil, err
+	}
 
 	gridStatus := &GridStatusData{
 		GridStatus:         liveStatus.Response.GridStatus,
@@ -302,6 +552,11 @@ func (c *Client) GetGridStatus() (*GridStatusData, error) {
 // timeZone specifies the timezone (optional, defaults to site timezone).
 // This provides charge telemetry data over the specified time period.
 func (c *Client) GetTelemetryHistory(startDate, endDate string, timeZone ...string) (*HistoryData, error) {
+	return c.GetTelemetryHistoryContext(context.Background(), startDate, endDate, timeZone...)
+}
+
+// GetTelemetryHistoryContext is like GetTelemetryHistory but uses ctx for cancellation and tracing.
+func (c *Client) GetTelemetryHistoryContext(ctx context.Context, startDate, endDate string, timeZone ...string) (*HistoryData, error) {
 	if err := c.ensureSiteSelected(); err != nil {
 		return nil, err
 	}
@@ -326,7 +581,7 @@ func (c *Client) GetTelemetryHistory(startDate, endDate string, timeZone ...stri
 		Response HistoryData `json:"response"`
 	}
 
-	err := c.apiGetJson(fullEndpoint, &historyResponse)
+	err := c.apiGetJson(ctx, fullEndpoint, &historyResponse)
 	if err != nil {
 		return nil, err
 	}
@@ -343,6 +598,11 @@ func (c *Client) GetTelemetryHistory(startDate, endDate string, timeZone ...stri
 // timeZone specifies the timezone (optional, defaults to site timezone).
 // This provides energy import/export totals for solar, battery, grid over time.
 func (c *Client) GetEnergyHistory(startDate, endDate, period string, timeZone ...string) (*HistoryData, error) {
+	return c.GetEnergyHistoryContext(context.Background(), startDate, endDate, period, timeZone...)
+}
+
+// GetEnergyHistoryContext is like GetEnergyHistory but uses ctx for cancellation and tracing.
+func (c *Client) GetEnergyHistoryContext(ctx context.Context, startDate, endDate, period string, timeZone ...string) (*HistoryData, error) {
 	if err := c.ensureSiteSelected(); err != nil {
 		return nil, err
 	}
@@ -380,7 +640,7 @@ func (c *Client) GetEnergyHistory(startDate, endDate, period string, timeZone ..
 		Response HistoryData `json:"response"`
 	}
 
-	err := c.apiGetJson(fullEndpoint, &historyResponse)
+	err := c.apiGetJson(ctx, fullEndpoint, &historyResponse)
 	if err != nil {
 		return nil, err
 	}
@@ -397,6 +657,11 @@ func (c *Client) GetEnergyHistory(startDate, endDate, period string, timeZone ..
 // timeZone specifies the timezone (optional, defaults to site timezone).
 // This provides backup usage and outage information over time.
 func (c *Client) GetBackupHistory(startDate, endDate, period string, timeZone ...string) (*HistoryData, error) {
+	return c.GetBackupHistoryContext(context.Background(), startDate, endDate, period, timeZone...)
+}
+
+// GetBackupHistoryContext is like GetBackupHistory but uses ctx for cancellation and tracing.
+func (c *Client) GetBackupHistoryContext(ctx context.Context, startDate, endDate, period string, timeZone ...string) (*HistoryData, error) {
 	if err := c.ensureSiteSelected(); err != nil {
 		return nil, err
 	}
@@ -434,7 +699,7 @@ func (c *Client) GetBackupHistory(startDate, endDate, period string, timeZone ..
 		Response HistoryData `json:"response"`
 	}
 
-	err := c.apiGetJson(fullEndpoint, &historyResponse)
+	err := c.apiGetJson(ctx, fullEndpoint, &historyResponse)
 	if err != nil {
 		return nil, err
 	}
@@ -445,14 +710,74 @@ func (c *Client) GetBackupHistory(startDate, endDate, period string, timeZone ..
 	return &historyResponse.Response, nil
 }
 
+// GetPowerHistory retrieves historical power samples using calendar_history endpoint.
+// startDate and endDate define the date range in YYYY-MM-DD format.
+// period specifies the granularity: "day" or "week".
+// timeZone specifies the timezone (optional, defaults to site timezone).
+// This provides solar, battery and grid power in 15-minute intervals.
+func (c *Client) GetPowerHistory(startDate, endDate, period string, timeZone ...string) (*HistoryData, error) {
+	return c.GetPowerHistoryContext(context.Background(), startDate, endDate, period, timeZone...)
+}
+
+// GetPowerHistoryContext is like GetPowerHistory but uses ctx for cancellation and tracing.
+func (c *Client) GetPowerHistoryContext(ctx context.Context, startDate, endDate, period string, timeZone ...string) (*HistoryData, error) {
+	if err := c.ensureSiteSelected(); err != nil {
+		return nil, err
+	}
+
+	c.logf("Fetching power history for energy site %d, start=%s end=%s period=%s...", c.selectedSiteID, startDate, endDate, period)
+
+	// Validate period
+	validPeriods := map[string]bool{
+		"day":  true,
+		"week": true,
+	}
+
+	if !validPeriods[period] {
+		return nil, fmt.Errorf("invalid period for power history: %s (supported: day, week)", period)
+	}
+
+	// Build endpoint with query parameters using calendar_history
+	endpoint := fmt.Sprintf("/api/1/energy_sites/%d/calendar_history", c.selectedSiteID)
+	params := url.Values{}
+	params.Set("kind", "power")
+	params.Set("start_date", startDate)
+	params.Set("end_date", endDate)
+	params.Set("period", period)
+
+	// Add timezone if provided
+	if len(timeZone) > 0 && timeZone[0] != "" {
+		params.Set("time_zone", timeZone[0])
+	}
+
+	fullEndpoint := endpoint + "?" + params.Encode()
+
+	var historyResponse struct {
+		Response HistoryData `json:"response"`
+	}
+
+	err := c.apiGetJson(ctx, fullEndpoint, &historyResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	c.logf("Power history retrieved successfully: %d data points from %s to %s (%s periods)",
+		len(historyResponse.Response.TimeSeries), startDate, endDate, period)
+
+	return &historyResponse.Response, nil
+}
+
 // GetCalendarHistory retrieves historical data for a specific date range using calendar_history endpoint.
-// kind specifies the data type: "energy" or "backup".
-// GetCalendarHistory retrieves historical data for a specific date range using calendar_history endpoint.
-// kind specifies the data type: "energy" or "backup".
+// kind specifies the data type: "energy", "backup" or "power".
 // startDate and endDate define the date range in YYYY-MM-DD format.
 // period specifies the granularity: "day", "week", "month", "year".
 // timeZone specifies the timezone (optional, defaults to site timezone).
 func (c *Client) GetCalendarHistory(kind, startDate, endDate, period string, timeZone ...string) (*HistoryData, error) {
+	return c.GetCalendarHistoryContext(context.Background(), kind, startDate, endDate, period, timeZone...)
+}
+
+// GetCalendarHistoryContext is like GetCalendarHistory but uses ctx for cancellation and tracing.
+func (c *Client) GetCalendarHistoryContext(ctx context.Context, kind, startDate, endDate, period string, timeZone ...string) (*HistoryData, error) {
 	if err := c.ensureSiteSelected(); err != nil {
 		return nil, err
 	}
@@ -464,10 +789,11 @@ func (c *Client) GetCalendarHistory(kind, startDate, endDate, period string, tim
 	validKinds := map[string]bool{
 		"energy": true,
 		"backup": true,
+		"power":  true,
 	}
 
 	if !validKinds[kind] {
-		return nil, fmt.Errorf("invalid kind for calendar history: %s (supported: energy, backup)", kind)
+		return nil, fmt.Errorf("invalid kind for calendar history: %s (supported: energy, backup, power)", kind)
 	}
 
 	// Validate period
@@ -501,7 +827,7 @@ func (c *Client) GetCalendarHistory(kind, startDate, endDate, period string, tim
 		Response HistoryData `json:"response"`
 	}
 
-	err := c.apiGetJson(fullEndpoint, &historyResponse)
+	err := c.apiGetJson(ctx, fullEndpoint, &historyResponse)
 	if err != nil {
 		return nil, err
 	}
@@ -518,6 +844,11 @@ func (c *Client) GetCalendarHistory(kind, startDate, endDate, period string, tim
 // SetBackupReserve sets the battery backup reserve percentage (0-100).
 // This determines how much battery capacity is reserved for backup power during outages.
 func (c *Client) SetBackupReserve(percent int) error {
+	return c.SetBackupReserveContext(context.Background(), percent)
+}
+
+// SetBackupReserveContext is like SetBackupReserve but uses ctx for cancellation and tracing.
+func (c *Client) SetBackupReserveContext(ctx context.Context, percent int) error {
 	if err := c.ensureSiteSelected(); err != nil {
 		return err
 	}
@@ -534,8 +865,16 @@ func (c *Client) SetBackupReserve(percent int) error {
 
 	endpoint := fmt.Sprintf("/api/1/energy_sites/%d/backup", c.selectedSiteID)
 
-	var response map[string]interface{}
-	err := c.apiPostJson(endpoint, payload, &response)
+	err := c.runCommand(ctx, command{
+		setting:  "backup_reserve_percent",
+		value:    strconv.Itoa(percent),
+		endpoint: endpoint,
+		payload:  payload,
+		read: func() (string, error) {
+			reserve, err := c.GetBackupReserveContext(ctx)
+			return strconv.Itoa(reserve), err
+		},
+	})
 	if err != nil {
 		return err
 	}
@@ -546,6 +885,11 @@ func (c *Client) SetBackupReserve(percent int) error {
 
 // SetSiteName changes the display name of the energy site.
 func (c *Client) SetSiteName(name string) error {
+	return c.SetSiteNameContext(context.Background(), name)
+}
+
+// SetSiteNameContext is like SetSiteName but uses ctx for cancellation and tracing.
+func (c *Client) SetSiteNameContext(ctx context.Context, name string) error {
 	if err := c.ensureSiteSelected(); err != nil {
 		return err
 	}
@@ -562,8 +906,19 @@ func (c *Client) SetSiteName(name string) error {
 
 	endpoint := fmt.Sprintf("/api/1/energy_sites/%d/site_name", c.selectedSiteID)
 
-	var response map[string]interface{}
-	err := c.apiPostJson(endpoint, payload, &response)
+	err := c.runCommand(ctx, command{
+		setting:  "site_name",
+		value:    name,
+		endpoint: endpoint,
+		payload:  payload,
+		read: func() (string, error) {
+			cfg, err := c.GetSiteConfigContext(ctx)
+			if err != nil {
+				return "", err
+			}
+			return cfg.SiteName, nil
+		},
+	})
 	if err != nil {
 		return err
 	}
@@ -575,6 +930,11 @@ func (c *Client) SetSiteName(name string) error {
 // SetStormMode enables or disables Storm Watch mode.
 // When enabled, the system will charge to 100% and prepare for potential outages.
 func (c *Client) SetStormMode(enabled bool) error {
+	return c.SetStormModeContext(context.Background(), enabled)
+}
+
+// SetStormModeContext is like SetStormMode but uses ctx for cancellation and tracing.
+func (c *Client) SetStormModeContext(ctx context.Context, enabled bool) error {
 	if err := c.ensureSiteSelected(); err != nil {
 		return err
 	}
@@ -587,8 +947,16 @@ func (c *Client) SetStormMode(enabled bool) error {
 
 	endpoint := fmt.Sprintf("/api/1/energy_sites/%d/storm_mode", c.selectedSiteID)
 
-	var response map[string]interface{}
-	err := c.apiPostJson(endpoint, payload, &response)
+	err := c.runCommand(ctx, command{
+		setting:  "storm_mode_enabled",
+		value:    strconv.FormatBool(enabled),
+		endpoint: endpoint,
+		payload:  payload,
+		read: func() (string, error) {
+			enabled, err := c.GetStormModeContext(ctx)
+			return strconv.FormatBool(enabled), err
+		},
+	})
 	if err != nil {
 		return err
 	}
@@ -601,6 +969,147 @@ func (c *Client) SetStormMode(enabled bool) error {
 	return nil
 }
 
+// SetOperationMode changes how the battery is used (one of the OperationMode*
+// constants): "self_consumption" (Self-Powered), "autonomous" (Time-Based
+// Control) or "backup" (Backup-only).
+func (c *Client) SetOperationMode(mode string) error {
+	return c.SetOperationModeContext(context.Background(), mode)
+}
+
+// SetOperationModeContext is like SetOperationMode but uses ctx for cancellation and tracing.
+func (c *Client) SetOperationModeContext(ctx context.Context, mode string) error {
+	if err := c.ensureSiteSelected(); err != nil {
+		return err
+	}
+
+	validModes := map[string]bool{
+		OperationModeSelfConsumption: true,
+		OperationModeAutonomous:      true,
+		OperationModeBackup:          true,
+	}
+
+	if !validModes[mode] {
+		return fmt.Errorf("invalid operation mode: %s (supported: self_consumption, autonomous, backup)", mode)
+	}
+
+	c.logf("Setting operation mode to %s for energy site %d...", mode, c.selectedSiteID)
+
+	payload := map[string]interface{}{
+		"default_real_mode": mode,
+	}
+
+	endpoint := fmt.Sprintf("/api/1/energy_sites/%d/operation", c.selectedSiteID)
+
+	err := c.runCommand(ctx, command{
+		setting:  "default_real_mode",
+		value:    mode,
+		endpoint: endpoint,
+		payload:  payload,
+		read: func() (string, error) {
+			return c.GetOperationModeContext(ctx)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	c.logf("Operation mode set successfully to %s", mode)
+	return nil
+}
+
+// SetExportRule sets which energy may be exported to the grid (one of the
+// ExportRule* constants): "battery_ok" (solar and battery), "pv_only" (solar
+// only) or "never".
+func (c *Client) SetExportRule(rule string) error {
+	return c.SetExportRuleContext(context.Background(), rule)
+}
+
+// SetExportRuleContext is like SetExportRule but uses ctx for cancellation and tracing.
+func (c *Client) SetExportRuleContext(ctx context.Context, rule string) error {
+	if err := c.ensureSiteSelected(); err != nil {
+		return err
+	}
+
+	validRules := map[string]bool{
+		ExportRuleBatteryOK: true,
+		ExportRulePVOnly:    true,
+		ExportRuleNever:     true,
+	}
+
+	if !validRules[rule] {
+		return fmt.Errorf("invalid export rule: %s (supported: battery_ok, pv_only, never)", rule)
+	}
+
+	c.logf("Setting export rule to %s for energy site %d...", rule, c.selectedSiteID)
+
+	payload := map[string]interface{}{
+		"customer_preferred_export_rule": rule,
+	}
+
+	endpoint := fmt.Sprintf("/api/1/energy_sites/%d/grid_import_export", c.selectedSiteID)
+
+	err := c.runCommand(ctx, command{
+		setting:  "customer_preferred_export_rule",
+		value:    rule,
+		endpoint: endpoint,
+		payload:  payload,
+		read: func() (string, error) {
+			cfg, err := c.GetSiteConfigContext(ctx)
+			if err != nil {
+				return "", err
+			}
+			return cfg.Components.CustomerPreferredExportRule, nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	c.logf("Export rule set successfully to %s", rule)
+	return nil
+}
+
+// SetGridCharging allows or disallows charging the battery from the grid on
+// sites with solar.
+func (c *Client) SetGridCharging(allowed bool) error {
+	return c.SetGridChargingContext(context.Background(), allowed)
+}
+
+// SetGridChargingContext is like SetGridCharging but uses ctx for cancellation and tracing.
+func (c *Client) SetGridChargingContext(ctx context.Context, allowed bool) error {
+	if err := c.ensureSiteSelected(); err != nil {
+		return err
+	}
+
+	c.logf("Setting grid charging to %t for energy site %d...", allowed, c.selectedSiteID)
+
+	payload := map[string]interface{}{
+		"disallow_charge_from_grid_with_solar_installed": !allowed,
+	}
+
+	endpoint := fmt.Sprintf("/api/1/energy_sites/%d/grid_import_export", c.selectedSiteID)
+
+	err := c.runCommand(ctx, command{
+		setting:  "disallow_charge_from_grid_with_solar_installed",
+		value:    strconv.FormatBool(!allowed),
+		endpoint: endpoint,
+		payload:  payload,
+		read: func() (string, error) {
+			cfg, err := c.GetSiteConfigContext(ctx)
+			if err != nil {
+				return "", err
+			}
+			return strconv.FormatBool(cfg.Components.DisallowChargeFromGridWithSolarInstalled), nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	c.logf("Grid charging set successfully to %t", allowed)
+	return nil
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 // Unsupported methods - return appropriate errors
 
@@ -0,0 +1,160 @@
+// Auditing and dry-run of control commands
+//
+//	WithAuditSink(sink) - Record every POST request
+//	WithAuditActor(actor) - Name recorded as the author of commands
+//	WithDryRun() - Log and audit commands without sending them
+//	NewAuditLog(w) - JSON Lines audit sink
+//	OpenAuditLog(path) - JSON Lines audit sink appending to a file
+
+package powerwall
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+)
+
+// Audit record results
+const (
+	AuditResultOK     = "ok"
+	AuditResultError  = "error"
+	AuditResultDryRun = "dry_run"
+)
+
+// AuditRecord describes one command sent (or, in dry-run mode, not sent) to
+// the Fleet API.
+type AuditRecord struct {
+	Time     time.Time       `json:"time"`
+	Actor    string          `json:"actor"`
+	SiteID   int64           `json:"site_id"`
+	Endpoint string          `json:"endpoint"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+
+	// Previous is the value of the setting before the command, or empty if
+	// it couldn't be read.
+	Previous string `json:"previous,omitempty"`
+
+	Result string `json:"result"` // One of the AuditResult* constants
+	Error  string `json:"error,omitempty"`
+}
+
+// AuditSink is the interface implemented by audit log destinations.
+type AuditSink interface {
+	WriteAudit(record AuditRecord) error
+}
+
+// WithAuditSink records every POST request made by the client in sink.
+// Failures to write a record are reported through the function registered
+// with SetErrFunc and don't fail the command.
+func WithAuditSink(sink AuditSink) func(c *Client) {
+	return func(c *Client) {
+		c.auditSink = sink
+	}
+}
+
+// WithAuditActor sets the name recorded as the author of commands.  It
+// defaults to the local user and host name, e.g. "alice@ops-1".
+func WithAuditActor(actor string) func(c *Client) {
+	return func(c *Client) {
+		c.auditActor = actor
+	}
+}
+
+// WithDryRun makes the client log and audit commands instead of sending
+// them.  Commands return nil as if they had succeeded; reads are unaffected.
+func WithDryRun() func(c *Client) {
+	return func(c *Client) {
+		c.dryRun = true
+	}
+}
+
+// audit writes a record of a POST request to the audit sink, if any.
+func (c *Client) audit(endpoint string, payload []byte, previous string, err error) {
+	if c.auditSink == nil {
+		return
+	}
+
+	record := AuditRecord{
+		Time:     time.Now(),
+		Actor:    c.auditActor,
+		SiteID:   c.selectedSiteID,
+		Endpoint: endpoint,
+		Payload:  payload,
+		Previous: previous,
+		Result:   AuditResultOK,
+	}
+	if record.Actor == "" {
+		record.Actor = defaultAuditActor()
+	}
+	switch {
+	case c.dryRun:
+		record.Result = AuditResultDryRun
+	case err != nil:
+		record.Result = AuditResultError
+		record.Error = err.Error()
+	}
+
+	if err := c.auditSink.WriteAudit(record); err != nil {
+		c.logError(fmt.Sprintf("Writing audit record for %s", endpoint), err)
+	}
+}
+
+// defaultAuditActor returns "user@host" for the local user.
+func defaultAuditActor() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, _ := os.Hostname()
+	return name + "@" + host
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// JSON Lines audit log
+
+// AuditLog is an AuditSink which writes one JSON object per line.
+type AuditLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewAuditLog creates an AuditLog which writes to w.
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{w: w}
+}
+
+// OpenAuditLog creates an AuditLog which appends to the file at path,
+// creating it if necessary.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{w: f, closer: f}, nil
+}
+
+// WriteAudit appends a record to the log.
+func (l *AuditLog) WriteAudit(record AuditRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the underlying file if the log was opened with OpenAuditLog.
+func (l *AuditLog) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
@@ -0,0 +1,208 @@
+// Response caching
+//
+//	WithCache(ttls) - Cache products, site_info and live_status responses
+//	(*Client) ClearCache() - Drop all cached responses
+
+package powerwall
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// CacheTTLs are how long each kind of response is cached.  A zero TTL
+// disables caching of that kind of response.
+type CacheTTLs struct {
+	Products   time.Duration // products, including energy site names
+	SiteInfo   time.Duration // site_info, used by most Get* configuration methods
+	LiveStatus time.Duration // live_status, used by the real-time Get* methods
+}
+
+// DefaultCacheTTLs suit dashboards which read the same site many times a
+// minute.
+var DefaultCacheTTLs = CacheTTLs{
+	Products:   6 * time.Hour,
+	SiteInfo:   5 * time.Minute,
+	LiveStatus: 5 * time.Second,
+}
+
+// WithCache caches GET responses for the given TTLs.  Concurrent identical
+// requests are coalesced into one, and a site's cached responses are dropped
+// after a successful control command on that site.  Caching is disabled by
+// default.
+func WithCache(ttls CacheTTLs) func(c *Client) {
+	return func(c *Client) {
+		c.cache = &responseCache{
+			ttls:        ttls,
+			entries:     map[string]cacheEntry{},
+			generations: map[string]uint64{},
+		}
+	}
+}
+
+// ClearCache drops all cached responses.
+func (c *Client) ClearCache() {
+	if c.cache != nil {
+		c.cache.invalidate("")
+	}
+}
+
+type cacheEntry struct {
+	body    []byte
+	expires time.Time
+}
+
+type responseCache struct {
+	ttls CacheTTLs
+
+	mu          sync.Mutex
+	entries     map[string]cacheEntry // Keyed by endpoint, including site ID and query
+	generations map[string]uint64     // Invalidations of each endpoint prefix
+	group       singleflight.Group
+}
+
+// ttl returns how long responses from endpoint are cached.
+func (rc *responseCache) ttl(endpoint string) time.Duration {
+	template := endpointTemplate(endpoint)
+	switch {
+	case strings.HasSuffix(template, "/products"):
+		return rc.ttls.Products
+	case strings.HasSuffix(template, "/site_info"):
+		return rc.ttls.SiteInfo
+	case strings.HasSuffix(template, "/live_status"):
+		return rc.ttls.LiveStatus
+	}
+	return 0
+}
+
+func (rc *responseCache) get(endpoint string) ([]byte, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	entry, ok := rc.entries[endpoint]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(rc.entries, endpoint)
+		return nil, false
+	}
+	return entry.body, true
+}
+
+// generation returns a counter which changes whenever endpoint's cached
+// response is invalidated.
+func (rc *responseCache) generation(endpoint string) uint64 {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return rc.generationLocked(endpoint)
+}
+
+func (rc *responseCache) generationLocked(endpoint string) uint64 {
+	var generation uint64
+	for prefix, n := range rc.generations {
+		if strings.HasPrefix(endpoint, prefix) {
+			generation += n
+		}
+	}
+	return generation
+}
+
+// put caches a response fetched at the given generation.  A response which
+// was invalidated while it was being fetched isn't cached.
+func (rc *responseCache) put(endpoint string, body []byte, ttl time.Duration, generation uint64) bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.generationLocked(endpoint) != generation {
+		return false
+	}
+	rc.entries[endpoint] = cacheEntry{body: body, expires: time.Now().Add(ttl)}
+	return true
+}
+
+// invalidate drops cached responses for endpoints starting with prefix.
+func (rc *responseCache) invalidate(prefix string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.generations[prefix]++
+	for endpoint := range rc.entries {
+		if strings.HasPrefix(endpoint, prefix) {
+			delete(rc.entries, endpoint)
+		}
+	}
+}
+
+// cachedGet returns the response body of a GET request, from the cache if
+// possible.  Concurrent misses for the same endpoint share one request.  It
+// keeps the values of the first caller's context but not its cancellation,
+// so one caller giving up doesn't fail the others; the shared request is
+// bounded by the request timeout instead, and each caller stops waiting when
+// its own ctx is cancelled.
+//
+// Requests are only shared within a cache generation: once a command has
+// invalidated the endpoint, new callers start a fresh request rather than
+// joining one which may return the state from before the command, and that
+// older response isn't cached.
+func (c *Client) cachedGet(ctx context.Context, endpoint string) ([]byte, error) {
+	if c.cache == nil {
+		return c.doFleetRequest(ctx, "GET", endpoint, nil)
+	}
+	ttl := c.cache.ttl(endpoint)
+	if ttl <= 0 {
+		return c.doFleetRequest(ctx, "GET", endpoint, nil)
+	}
+
+	if body, ok := c.cache.get(endpoint); ok {
+		c.logf("Cache hit: %s", endpoint)
+		return body, nil
+	}
+
+	generation := c.cache.generation(endpoint)
+	key := fmt.Sprintf("%s#%d", endpoint, generation)
+	ch := c.cache.group.DoChan(key, func() (interface{}, error) {
+		shared := context.WithoutCancel(ctx)
+		if timeout := c.requestTimeout("GET", endpoint); timeout > 0 {
+			var cancel context.CancelFunc
+			shared, cancel = context.WithTimeout(shared, timeout)
+			defer cancel()
+		}
+		body, err := c.doFleetRequest(shared, "GET", endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		if !c.cache.put(endpoint, body, ttl, generation) {
+			c.logf("Not caching response invalidated during request: %s", endpoint)
+		}
+		return body, nil
+	})
+	select {
+	case result := <-ch:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		if result.Shared {
+			c.logf("Coalesced request: %s", endpoint)
+		}
+		return result.Val.([]byte), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// invalidateSite drops the cached responses of an energy site, and products,
+// which include the site's name.
+func (c *Client) invalidateSite(siteID int64) {
+	if c.cache == nil {
+		return
+	}
+	c.cache.invalidate(fmt.Sprintf("/api/1/energy_sites/%d/", siteID))
+	c.cache.invalidate("/api/1/products")
+}
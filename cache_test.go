@@ -0,0 +1,112 @@
+package powerwall
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingSiteInfo serves site_info with the current backup reserve.  The
+// first site_info request is held until release is closed.
+type blockingSiteInfo struct {
+	started chan struct{}
+	release chan struct{}
+
+	mu       sync.Mutex
+	reserve  int
+	requests int
+}
+
+func (b *blockingSiteInfo) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body string
+	if req.Method == http.MethodPost {
+		b.mu.Lock()
+		b.reserve = 30
+		b.mu.Unlock()
+		body = `{"response":{"code":201,"message":"Updated"}}`
+	} else {
+		b.mu.Lock()
+		b.requests++
+		first := b.requests == 1
+		reserve := b.reserve
+		b.mu.Unlock()
+		if first {
+			close(b.started)
+			<-b.release
+		}
+		body = fmt.Sprintf(`{"response":{"backup_reserve_percent":%d}}`, reserve)
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func TestCachedGetInvalidatedDuringRequest(t *testing.T) {
+	transport := &blockingSiteInfo{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+		reserve: 20,
+	}
+	c := NewClient("id", "access", "refresh",
+		WithHttpClient(&http.Client{Transport: transport}),
+		WithCache(CacheTTLs{SiteInfo: time.Hour}))
+	c.tokenExpiry = time.Now().Add(time.Hour)
+	c.rateLimitConfig.RealtimeDataRPM = 6000 // No waits between requests
+	c.SelectEnergySite(1234)
+
+	before := make(chan int, 1)
+	go func() {
+		reserve, err := c.GetBackupReserve()
+		if err != nil {
+			t.Error(err)
+		}
+		before <- reserve
+	}()
+	<-transport.started
+
+	if err := c.SetBackupReserve(30); err != nil {
+		t.Fatal(err)
+	}
+
+	// A read after the command mustn't join the request still in flight
+	after := make(chan int, 1)
+	go func() {
+		reserve, err := c.GetBackupReserve()
+		if err != nil {
+			t.Error(err)
+		}
+		after <- reserve
+	}()
+	select {
+	case reserve := <-after:
+		if reserve != 30 {
+			t.Errorf("reserve after the command = %d, want 30", reserve)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read after the command joined the request from before it")
+	}
+
+	close(transport.release)
+	if reserve := <-before; reserve != 20 {
+		t.Errorf("reserve from the request before the command = %d, want 20", reserve)
+	}
+
+	// The older response mustn't replace the newer one in the cache
+	reserve, err := c.GetBackupReserve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reserve != 30 {
+		t.Errorf("cached reserve = %d, want 30", reserve)
+	}
+	if transport.requests != 2 {
+		t.Errorf("site_info requested %d times, want 2", transport.requests)
+	}
+}
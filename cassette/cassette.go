@@ -0,0 +1,97 @@
+// Package cassette records Fleet API sessions to fixture files and replays
+// them, so code using a powerwall.Client can be tested without network
+// access or credentials.
+//
+// A Recorder is an http.RoundTripper which passes requests through to the
+// real API and keeps each request/response pair, redacting tokens, energy
+// site IDs, serial numbers and addresses before anything is written:
+//
+//	recorder := cassette.NewRecorder(http.DefaultTransport)
+//	client := powerwall.NewClient(clientID, accessToken, refreshToken,
+//		powerwall.WithHttpClient(&http.Client{Transport: recorder}))
+//	// ... use the client ...
+//	err := recorder.Save("testdata/live_status.json")
+//
+// A Player serves a saved cassette, matching requests on method, path and
+// query.  Requests without a recorded response fail with an error naming the
+// request:
+//
+//	player, err := cassette.Load("testdata/live_status.json")
+//	client := powerwall.NewClient("id", "token", "refresh",
+//		powerwall.WithHttpClient(&http.Client{Transport: player}))
+package cassette
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// Cassette is a recorded sequence of interactions.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one request and the response it received.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is the part of a recorded request used for matching, plus the body
+// for reference.
+type Request struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Query  string `json:"query,omitempty"` // Encoded with url.Values.Encode, so keys are sorted
+	Body   string `json:"body,omitempty"`
+}
+
+// Response is a recorded response.
+type Response struct {
+	StatusCode int                 `json:"status_code"`
+	Header     map[string][]string `json:"header,omitempty"`
+	Body       string              `json:"body"`
+}
+
+// key returns the string requests are matched on.
+func (r Request) key() string {
+	if r.Query == "" {
+		return r.Method + " " + r.Path
+	}
+	return r.Method + " " + r.Path + "?" + r.Query
+}
+
+// canonicalQuery re-encodes a raw query string so that parameter order
+// doesn't affect matching.
+func canonicalQuery(raw string) string {
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return raw
+	}
+	return values.Encode()
+}
+
+// Load reads a cassette from path and returns a Player for it.
+func Load(path string) (*Player, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("reading cassette %s: %w", path, err)
+	}
+	return NewPlayer(&c, path), nil
+}
+
+// Save writes the cassette to path as indented JSON.
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
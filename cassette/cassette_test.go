@@ -0,0 +1,77 @@
+package cassette_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/blampe/powerwall"
+	"github.com/blampe/powerwall/cassette"
+)
+
+// testdata/site.json was recorded with a Recorder and is redacted, so the
+// site ID is the first fake one.
+const fixtureSiteID = 1000000000000001
+
+func TestReplayThroughClient(t *testing.T) {
+	player, err := cassette.Load("testdata/site.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := powerwall.NewClient("client-id", "", "refresh-token",
+		powerwall.WithHttpClient(&http.Client{Transport: player}))
+
+	products, err := client.GetEnergyProducts()
+	if err != nil {
+		t.Fatalf("GetEnergyProducts: %v", err)
+	}
+	if len(products) != 1 || products[0].EnergyProductID != fixtureSiteID {
+		t.Fatalf("GetEnergyProducts = %+v, want site %d", products, fixtureSiteID)
+	}
+	if err := client.SelectEnergySite(products[0].EnergyProductID); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := client.GetSiteInfo()
+	if err != nil {
+		t.Fatalf("GetSiteInfo: %v", err)
+	}
+	if info.SiteName != "Main St" || info.TimeZone != "America/Los_Angeles" {
+		t.Errorf("GetSiteInfo = %q in %q, want \"Main St\" in America/Los_Angeles", info.SiteName, info.TimeZone)
+	}
+
+	// The recorded Date header dates the response, so the old timestamp
+	// isn't reported as stale on replay
+	status, err := client.GetLiveStatus()
+	if err != nil {
+		t.Fatalf("GetLiveStatus: %v", err)
+	}
+	if status.PercentageCharged == nil || *status.PercentageCharged != 81.5 {
+		t.Errorf("PercentageCharged = %v, want 81.5", status.PercentageCharged)
+	}
+
+	if err := client.SetBackupReserve(30); err != nil {
+		t.Fatalf("SetBackupReserve: %v", err)
+	}
+
+	if unmatched := player.Unmatched(); len(unmatched) > 0 {
+		t.Errorf("requests without a recorded response: %v", unmatched)
+	}
+	if unused := player.Unused(); len(unused) > 0 {
+		t.Errorf("recorded requests never made: %v", unused)
+	}
+}
+
+func TestPlayerUnmatched(t *testing.T) {
+	player := cassette.NewPlayer(&cassette.Cassette{}, "empty")
+	client := powerwall.NewClient("client-id", "", "refresh-token",
+		powerwall.WithHttpClient(&http.Client{Transport: player}))
+
+	err := client.RefreshToken()
+	if err == nil || !strings.Contains(err.Error(), "POST /oauth2/v3/token") {
+		t.Errorf("RefreshToken error = %v, want one naming the request", err)
+	}
+	if got := player.Unmatched(); len(got) != 1 || got[0] != "POST /oauth2/v3/token" {
+		t.Errorf("Unmatched = %v", got)
+	}
+}
@@ -0,0 +1,100 @@
+package cassette
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Player is an http.RoundTripper which serves the responses of a cassette.
+//
+// Identical requests are served their recorded responses in order; once
+// those run out, the last one is served again, so polling loops can run for
+// longer than they did while recording.  A request which was never recorded
+// returns an error.
+type Player struct {
+	name string
+
+	mu        sync.Mutex
+	responses map[string][]Response
+	served    map[string]int
+	unmatched []string
+}
+
+// NewPlayer creates a Player for a cassette.  name identifies the cassette
+// in error messages.
+func NewPlayer(c *Cassette, name string) *Player {
+	p := &Player{
+		name:      name,
+		responses: map[string][]Response{},
+		served:    map[string]int{},
+	}
+	for _, interaction := range c.Interactions {
+		key := interaction.Request.key()
+		p.responses[key] = append(p.responses[key], interaction.Response)
+	}
+	return p
+}
+
+// RoundTrip serves the recorded response for req.
+func (p *Player) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := Request{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Query:  canonicalQuery(req.URL.RawQuery),
+	}.key()
+
+	p.mu.Lock()
+	responses := p.responses[key]
+	if len(responses) == 0 {
+		p.unmatched = append(p.unmatched, key)
+		p.mu.Unlock()
+		return nil, fmt.Errorf("cassette %s: no recorded response for %s", p.name, key)
+	}
+	i := min(p.served[key], len(responses)-1)
+	p.served[key]++
+	recorded := responses[i]
+	p.mu.Unlock()
+
+	header := http.Header{}
+	for name, values := range recorded.Header {
+		header[name] = append([]string(nil), values...)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewBufferString(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}, nil
+}
+
+// Unmatched returns the requests which had no recorded response, so tests
+// can fail even if the code under test swallowed the error.
+func (p *Player) Unmatched() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.unmatched...)
+}
+
+// Unused returns the recorded requests which were never served, which
+// usually means the code under test no longer makes them.
+func (p *Player) Unused() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var unused []string
+	for key := range p.responses {
+		if p.served[key] == 0 {
+			unused = append(unused, key)
+		}
+	}
+	return unused
+}
@@ -0,0 +1,108 @@
+package cassette
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Recorder is an http.RoundTripper which sends requests with another
+// RoundTripper and records each request and response.  Nothing is redacted
+// until the cassette is saved, so the client works normally while recording.
+type Recorder struct {
+	next     http.RoundTripper
+	redactor *Redactor
+
+	mu           sync.Mutex
+	interactions []Interaction
+}
+
+// WithRedactKeys redacts additional JSON keys from recorded bodies.
+func WithRedactKeys(keys ...string) func(r *Recorder) {
+	return func(r *Recorder) {
+		for _, key := range keys {
+			r.redactor.secretKeys[key] = true
+		}
+	}
+}
+
+// NewRecorder creates a Recorder which sends requests with next, or
+// http.DefaultTransport if next is nil.
+func NewRecorder(next http.RoundTripper, options ...func(r *Recorder)) *Recorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	r := &Recorder{
+		next:     next,
+		redactor: NewRedactor(),
+	}
+
+	// Apply options
+	for _, option := range options {
+		if option != nil {
+			option(r)
+		}
+	}
+
+	return r
+}
+
+// RoundTrip sends req and records it with its response.  Requests which fail
+// without a response aren't recorded.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.interactions = append(r.interactions, Interaction{
+		Request: Request{
+			Method: req.Method,
+			Path:   req.URL.Path,
+			Query:  canonicalQuery(req.URL.RawQuery),
+			Body:   string(reqBody),
+		},
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header.Clone(),
+			Body:       string(respBody),
+		},
+	})
+
+	return resp, nil
+}
+
+// Cassette returns the interactions recorded so far, redacted.
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.redactor.Redact(&Cassette{Interactions: r.interactions})
+}
+
+// Save writes the interactions recorded so far to path, redacted.
+func (r *Recorder) Save(path string) error {
+	return r.Cassette().Save(path)
+}
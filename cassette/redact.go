@@ -0,0 +1,281 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Redacted replaces secrets and addresses in recorded bodies.
+const Redacted = "REDACTED"
+
+// Energy site IDs and serial numbers are replaced with fake values rather than
+// a constant, so that requests for different sites still match different
+// responses on replay.
+const (
+	fakeSiteIDBase = 1000000000000000
+	fakeSerialFmt  = "SERIAL%04d"
+
+	// minReplaceLen is the shortest identifier which is also replaced where
+	// it appears in URLs and free text.  Shorter ones are only replaced in
+	// their own JSON fields, since they could match unrelated text.
+	minReplaceLen = 6
+)
+
+var (
+	secretKeys = []string{
+		"access_token", "refresh_token", "id_token", "client_id", "client_secret", "code", "email",
+	}
+	siteIDKeys = []string{
+		"energy_site_id", "site_id",
+	}
+	serialKeys = []string{
+		"id", "asset_site_id", "gateway_id", "din", "serial_number", "device_serial", "device_id",
+		"ecu_package_serial_number", "site_uid", "short_id", "hw_address", "vin",
+	}
+	addressKeys = []string{
+		"address", "address_line1", "address_line2", "street", "street_address", "city",
+		"zip", "zip_code", "postal_code", "latitude", "longitude", "geolocation",
+	}
+
+	// siteIDPath matches energy site IDs in Fleet API paths.
+	siteIDPath = regexp.MustCompile(`/energy_sites/(\d+)`)
+
+	// droppedHeaders aren't recorded.  Content-Length is dropped because
+	// redaction changes the length of the body.
+	droppedHeaders = []string{"Set-Cookie", "Authorization", "Content-Length"}
+)
+
+// Redactor removes tokens, energy site IDs, serial numbers and addresses from
+// cassettes.  The same real value is always replaced with the same fake one,
+// so a site ID in a products response still matches the paths of later
+// requests for that site.
+type Redactor struct {
+	secretKeys  map[string]bool
+	siteIDKeys  map[string]bool
+	serialKeys  map[string]bool
+	addressKeys map[string]bool
+
+	replacements map[string]string
+	siteIDs      int
+	serials      int
+}
+
+// NewRedactor creates a Redactor for the fields returned by the Fleet API.
+func NewRedactor() *Redactor {
+	return &Redactor{
+		secretKeys:   keySet(secretKeys),
+		siteIDKeys:   keySet(siteIDKeys),
+		serialKeys:   keySet(serialKeys),
+		addressKeys:  keySet(addressKeys),
+		replacements: map[string]string{},
+	}
+}
+
+func keySet(keys []string) map[string]bool {
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		set[key] = true
+	}
+	return set
+}
+
+// Redact returns a redacted copy of c.
+func (r *Redactor) Redact(c *Cassette) *Cassette {
+	redacted := &Cassette{Interactions: make([]Interaction, len(c.Interactions))}
+
+	// Redact JSON fields first, collecting the identifiers they contain, so
+	// that those can then be replaced wherever else they appear.
+	for i, interaction := range c.Interactions {
+		for _, match := range siteIDPath.FindAllStringSubmatch(interaction.Request.Path, -1) {
+			r.siteID(match[1])
+		}
+		interaction.Request.Body = r.redactBody(interaction.Request.Body)
+		interaction.Response.Body = r.redactBody(interaction.Response.Body)
+		interaction.Response.Header = redactHeader(interaction.Response.Header)
+		redacted.Interactions[i] = interaction
+	}
+
+	replacer := r.replacer()
+	for i := range redacted.Interactions {
+		interaction := &redacted.Interactions[i]
+		interaction.Request.Path = replacer.Replace(interaction.Request.Path)
+		interaction.Request.Query = canonicalQuery(replacer.Replace(interaction.Request.Query))
+		interaction.Request.Body = replacer.Replace(interaction.Request.Body)
+		interaction.Response.Body = replacer.Replace(interaction.Response.Body)
+	}
+	return redacted
+}
+
+// siteID returns the fake energy site ID for a real one.
+func (r *Redactor) siteID(real string) string {
+	if fake, ok := r.replacements[real]; ok {
+		return fake
+	}
+	r.siteIDs++
+	fake := fmt.Sprint(fakeSiteIDBase + r.siteIDs)
+	r.replacements[real] = fake
+	return fake
+}
+
+// serial returns the fake serial number for a real one.
+func (r *Redactor) serial(real string) string {
+	if fake, ok := r.replacements[real]; ok {
+		return fake
+	}
+	r.serials++
+	fake := fmt.Sprintf(fakeSerialFmt, r.serials)
+	r.replacements[real] = fake
+	return fake
+}
+
+// replacer replaces every collected identifier long enough to be replaced
+// safely, longest first.
+func (r *Redactor) replacer() *strings.Replacer {
+	reals := make([]string, 0, len(r.replacements))
+	for real := range r.replacements {
+		if len(real) >= minReplaceLen {
+			reals = append(reals, real)
+		}
+	}
+	sort.Slice(reals, func(i, j int) bool {
+		if len(reals[i]) != len(reals[j]) {
+			return len(reals[i]) > len(reals[j])
+		}
+		return reals[i] < reals[j]
+	})
+
+	pairs := make([]string, 0, 2*len(reals))
+	for _, real := range reals {
+		pairs = append(pairs, real, r.replacements[real])
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// redactBody redacts a JSON or form-encoded body.  Other bodies are returned
+// unchanged.
+func (r *Redactor) redactBody(body string) string {
+	if body == "" {
+		return body
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err == nil {
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(r.redactValue("", value)); err == nil {
+			return strings.TrimSuffix(buf.String(), "\n")
+		}
+	}
+
+	if form, err := url.ParseQuery(body); err == nil {
+		redacted := false
+		for key := range form {
+			if r.secretKeys[key] {
+				r.collectSecret(form.Get(key))
+				form.Set(key, Redacted)
+				redacted = true
+			}
+		}
+		if redacted {
+			return form.Encode()
+		}
+	}
+	return body
+}
+
+// collectSecret makes sure a secret is also replaced wherever else it
+// appears, such as a refresh token echoed in a response.
+func (r *Redactor) collectSecret(secret string) {
+	if secret != "" && secret != Redacted {
+		r.replacements[secret] = Redacted
+	}
+}
+
+// redactValue redacts a decoded JSON value found under key.
+func (r *Redactor) redactValue(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		// Visit keys in order so fake identifiers don't depend on map order
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if r.addressKeys[k] {
+				v[k] = redactAll(v[k])
+			} else {
+				v[k] = r.redactValue(k, v[k])
+			}
+		}
+		return v
+	case []interface{}:
+		for i := range v {
+			v[i] = r.redactValue(key, v[i])
+		}
+		return v
+	case json.Number:
+		if r.siteIDKeys[key] || r.serialKeys[key] {
+			return json.Number(r.siteID(v.String()))
+		}
+		return v
+	case string:
+		switch {
+		case v == "":
+			return v
+		case r.secretKeys[key]:
+			r.collectSecret(v)
+			return Redacted
+		case r.siteIDKeys[key]:
+			return r.siteID(v)
+		case r.serialKeys[key]:
+			return r.serial(v)
+		}
+		return v
+	}
+	return value
+}
+
+// redactAll replaces every string and number in value, keeping its shape so
+// that it still decodes into the same types.
+func redactAll(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = redactAll(v[k])
+		}
+		return v
+	case []interface{}:
+		for i := range v {
+			v[i] = redactAll(v[i])
+		}
+		return v
+	case json.Number:
+		return json.Number("0")
+	case string:
+		return Redacted
+	}
+	return value
+}
+
+// redactHeader returns a copy of header without cookies, credentials or
+// the original body length.
+func redactHeader(header map[string][]string) map[string][]string {
+	if header == nil {
+		return nil
+	}
+	redacted := http.Header(header).Clone()
+	for _, name := range droppedHeaders {
+		redacted.Del(name)
+	}
+	return redacted
+}
@@ -0,0 +1,143 @@
+package cassette_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/blampe/powerwall/cassette"
+)
+
+func TestRedact(t *testing.T) {
+	const (
+		siteID       = "2252147418962514"
+		refreshToken = "NA_refresh-4b8d2e6f91"
+		accessToken  = "eyJhbGciOiJSUzI1NiJ9.access-7f3c9a1e"
+		clientID     = "ownerapi-client-9e8d7c"
+		gatewayDIN   = "1152100-14-J--TG123456789ABC"
+	)
+	c := &cassette.Cassette{Interactions: []cassette.Interaction{
+		{
+			Request: cassette.Request{
+				Method: "POST",
+				Path:   "/oauth2/v3/token",
+				Body:   "client_id=" + clientID + "&grant_type=refresh_token&refresh_token=" + refreshToken,
+			},
+			Response: cassette.Response{
+				StatusCode: 200,
+				Header:     map[string][]string{"Set-Cookie": {"session=abc"}, "Content-Length": {"123"}},
+				Body:       `{"access_token":"` + accessToken + `","refresh_token":"` + refreshToken + `","expires_in":28800}`,
+			},
+		},
+		{
+			Request: cassette.Request{Method: "GET", Path: "/api/1/products"},
+			Response: cassette.Response{
+				StatusCode: 200,
+				Body:       `{"response":[{"energy_site_id":` + siteID + `,"gateway_id":"` + gatewayDIN + `","site_name":"Main St"}]}`,
+			},
+		},
+		{
+			Request: cassette.Request{Method: "GET", Path: "/api/1/energy_sites/" + siteID + "/site_info"},
+			Response: cassette.Response{
+				StatusCode: 200,
+				Body: `{"response":{"address":{"address_line1":"742 Evergreen Terrace","city":"Springfield","zip":"94105"},` +
+					`"geolocation":{"latitude":37.7749,"longitude":-122.4194},` +
+					`"components":{"gateways":[{"din":"` + gatewayDIN + `"}]},"installation_time_zone":"America/Los_Angeles"}}`,
+			},
+		},
+		{
+			Request: cassette.Request{
+				Method: "POST",
+				Path:   "/api/1/energy_sites/" + siteID + "/backup",
+				Body:   `{"backup_reserve_percent":30}`,
+			},
+			Response: cassette.Response{
+				StatusCode: 400,
+				Body:       `{"error":"site ` + siteID + ` rejected token ` + refreshToken + `"}`,
+			},
+		},
+	}}
+
+	redacted := cassette.NewRedactor().Redact(c)
+	data, err := json.Marshal(redacted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{siteID, refreshToken, accessToken, clientID, gatewayDIN, "Evergreen", "Springfield", "94105", "37.7749", "session=abc"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("redacted cassette still contains %q", secret)
+		}
+	}
+
+	// The same site ID becomes the same fake one in bodies and paths, so
+	// replayed requests still match
+	fakeID := "1000000000000001"
+	if !strings.Contains(redacted.Interactions[1].Response.Body, `"energy_site_id":`+fakeID) {
+		t.Errorf("products body = %s, want energy_site_id %s", redacted.Interactions[1].Response.Body, fakeID)
+	}
+	for _, i := range []int{2, 3} {
+		if want := "/api/1/energy_sites/" + fakeID + "/"; !strings.HasPrefix(redacted.Interactions[i].Request.Path, want) {
+			t.Errorf("path = %s, want prefix %s", redacted.Interactions[i].Request.Path, want)
+		}
+	}
+	if !strings.Contains(redacted.Interactions[3].Response.Body, "site "+fakeID) {
+		t.Errorf("error body = %s, want the fake site ID", redacted.Interactions[3].Response.Body)
+	}
+
+	// Serial numbers are consistent across responses too
+	var products struct {
+		Response []struct {
+			GatewayID string `json:"gateway_id"`
+		} `json:"response"`
+	}
+	var info struct {
+		Response struct {
+			Address     map[string]string  `json:"address"`
+			Geolocation map[string]float64 `json:"geolocation"`
+			Components  struct {
+				Gateways []struct {
+					DIN string `json:"din"`
+				} `json:"gateways"`
+			} `json:"components"`
+			TimeZone string `json:"installation_time_zone"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal([]byte(redacted.Interactions[1].Response.Body), &products); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(redacted.Interactions[2].Response.Body), &info); err != nil {
+		t.Fatalf("redacted site_info no longer decodes: %v", err)
+	}
+	if products.Response[0].GatewayID != info.Response.Components.Gateways[0].DIN {
+		t.Errorf("gateway_id %q and din %q differ", products.Response[0].GatewayID, info.Response.Components.Gateways[0].DIN)
+	}
+
+	// Address blocks keep their shape
+	for key, value := range info.Response.Address {
+		if value != cassette.Redacted {
+			t.Errorf("address %s = %q, want %q", key, value, cassette.Redacted)
+		}
+	}
+	if info.Response.Geolocation["latitude"] != 0 || info.Response.Geolocation["longitude"] != 0 {
+		t.Errorf("geolocation = %v, want zeros", info.Response.Geolocation)
+	}
+	if info.Response.TimeZone != "America/Los_Angeles" {
+		t.Errorf("installation_time_zone = %q, want it kept", info.Response.TimeZone)
+	}
+
+	// Tokens in the form-encoded request body
+	form := redacted.Interactions[0].Request.Body
+	for _, field := range []string{"client_id=REDACTED", "refresh_token=REDACTED", "grant_type=refresh_token"} {
+		if !strings.Contains(form, field) {
+			t.Errorf("token request body = %s, want %s", form, field)
+		}
+	}
+
+	header := redacted.Interactions[0].Response.Header
+	if _, ok := header["Set-Cookie"]; ok {
+		t.Error("Set-Cookie header was recorded")
+	}
+	if _, ok := header["Content-Length"]; ok {
+		t.Error("Content-Length header was recorded")
+	}
+}
@@ -4,25 +4,38 @@
 //
 //	NewClient(clientID, accessToken, refreshToken) - Creates Fleet API client
 //	(*Client) RefreshToken()
+//	(*Client) RefreshTokenContext(ctx)
 //	(*Client) SetRefreshToken()
 //	(*Client) GetRefreshToken()
 //	(*Client) IsTokenExpired()
 //	(*Client) SetRateLimit()
+//	(*Client) GetRateLimit()
 //	(*Client) GetRateLimitStatus()
 //	(*Client) GetAPIUsageStats()
+//	WithRequestObserver(f) - Registers a per-request callback
+//
+// See middleware.go for request middleware and timeouts, logging.go for
+// structured logging, tracing.go for OpenTelemetry tracing, cache.go for
+// response caching and freshness.go for detection of stale live_status data.
 
 package powerwall
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -40,6 +53,8 @@ var logFunc = func(v ...interface{}) {}
 // the same format as Printf/Sprintf/etc.  Note that log lines passed to this
 // function are *not* newline-terminated, so you will need to add newlines if
 // you want to put them out directly to stdout/stderr, etc.
+//
+// SetLogFunc applies to every client without a logger set with WithLogger.
 func SetLogFunc(f func(...interface{})) {
 	logFunc = f
 }
@@ -51,6 +66,8 @@ var errFunc = func(string, error) {}
 // don't want full debug logging, but still want to log additional information
 // that might be helpful when troubleshooting, for example, API message format
 // errors, etc.
+//
+// SetErrFunc applies to every client without a logger set with WithLogger.
 func SetErrFunc(f func(string, error)) {
 	errFunc = f
 }
@@ -64,6 +81,28 @@ type Client struct {
 	httpClient      *http.Client
 	selectedSiteID  int64
 	rateLimitConfig RateLimitConfig
+	requestObserver func(RequestInfo)
+	logger          *slog.Logger
+	tracer          trace.Tracer
+	cache           *responseCache
+
+	// Detection of stale live_status data
+	staleThreshold    time.Duration
+	clockMutex        sync.Mutex
+	serverClockOffset time.Duration
+
+	// Read-after-write confirmation of control commands, disabled if zero
+	commandVerifyTimeout time.Duration
+
+	// Request middleware, User-Agent and per-request timeouts
+	middleware      []Middleware
+	userAgent       string
+	requestTimeouts RequestTimeouts
+
+	// Auditing and dry-run of POST requests
+	auditSink  AuditSink
+	auditActor string
+	dryRun     bool
 
 	// Rate limiting
 	rateLimitMutex  sync.Mutex
@@ -84,12 +123,15 @@ func NewClient(clientID, accessToken, refreshToken string, options ...func(c *Cl
 		refreshToken: refreshToken,
 		clientID:     clientID,
 		httpClient:   httpClient,
+		userAgent:    DefaultUserAgent,
+		tracer:       otel.Tracer(tracerName),
 		rateLimitConfig: RateLimitConfig{
 			RealtimeDataRPM: 60, // Tesla's limit for live data
 			CommandsRPM:     30, // Tesla's limit for commands
 			MaxMonthlyCost:  10, // $10 free tier
 		},
-		requestQueue: make(chan struct{}, 1), // Single-threaded requests
+		requestQueue:   make(chan struct{}, 1), // Single-threaded requests
+		staleThreshold: DefaultStaleThreshold,
 	}
 
 	// Apply options
@@ -110,17 +152,57 @@ func WithHttpClient(httpClient *http.Client) func(c *Client) {
 	}
 }
 
-func (c *Client) logf(format string, v ...interface{}) {
-	logFunc(fmt.Sprintf("{FleetAPI %p} ", c) + fmt.Sprintf(format, v...))
+// RequestInfo describes a completed Fleet API request.  It is passed to the
+// callback registered with WithRequestObserver.
+type RequestInfo struct {
+	Method     string
+	Endpoint   string // Endpoint template, e.g. "/api/1/energy_sites/{id}/live_status"
+	StatusCode int    // 0 if no response was received
+	Duration   time.Duration
+	Err        error
+}
+
+// WithRequestObserver registers a callback which is called after every Fleet
+// API request completes, successfully or not.  This is intended for collecting
+// request metrics.
+func WithRequestObserver(f func(RequestInfo)) func(c *Client) {
+	return func(c *Client) {
+		c.requestObserver = f
+	}
+}
+
+// endpointTemplate strips the query string from an endpoint and replaces the
+// energy site ID with "{id}", so that endpoints can be used as
+// low-cardinality metric labels.  The API version is kept.
+func endpointTemplate(endpoint string) string {
+	if i := strings.IndexByte(endpoint, '?'); i >= 0 {
+		endpoint = endpoint[:i]
+	}
+	segments := strings.Split(endpoint, "/")
+	for i := 1; i < len(segments); i++ {
+		if segments[i-1] == "energy_sites" && segments[i] != "" {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
 }
 
 func (c *Client) jsonError(api string, data []byte, err error) {
 	msg := fmt.Sprintf("Error unmarshalling Fleet API '%s' response %s", api, string(data))
-	errFunc(msg, err)
+	c.logError(msg, err)
 }
 
 // RefreshToken refreshes the OAuth access token using the refresh token
 func (c *Client) RefreshToken() error {
+	return c.RefreshTokenContext(context.Background())
+}
+
+// RefreshTokenContext is like RefreshToken but uses ctx for cancellation and
+// tracing.
+func (c *Client) RefreshTokenContext(ctx context.Context) (err error) {
+	ctx, span := c.startSpan(ctx, "powerwall.RefreshToken")
+	defer func() { endSpan(span, err) }()
+
 	c.logf("Refreshing OAuth access token using client_id: %s", c.clientID)
 
 	data := url.Values{}
@@ -128,11 +210,12 @@ func (c *Client) RefreshToken() error {
 	data.Set("refresh_token", c.refreshToken)
 	data.Set("client_id", c.clientID) // Use the configured client ID
 
-	req, err := http.NewRequest("POST", TokenURL, strings.NewReader(data.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", TokenURL, strings.NewReader(data.Encode()))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", c.userAgent)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -213,6 +296,15 @@ func (c *Client) SetRateLimit(requestsPerMinute int) {
 	c.logf("Set rate limit to %d requests per minute", requestsPerMinute)
 }
 
+// GetRateLimit returns the configured client-side rate limit in requests per
+// minute
+func (c *Client) GetRateLimit() int {
+	c.rateLimitMutex.Lock()
+	defer c.rateLimitMutex.Unlock()
+
+	return c.rateLimitConfig.RealtimeDataRPM
+}
+
 // GetRateLimitStatus returns current rate limit information (placeholder)
 func (c *Client) GetRateLimitStatus() (remaining int, resetTime time.Time, err error) {
 	// TODO: Implement based on Tesla's actual rate limit headers
@@ -225,8 +317,12 @@ func (c *Client) GetAPIUsageStats() (requestCount int, cost float64, err error)
 	return 0, 0.0, nil
 }
 
-// rateLimitWait implements client-side rate limiting
-func (c *Client) rateLimitWait() {
+// rateLimitWait implements client-side rate limiting.  It returns early with
+// ctx's error if ctx is cancelled while waiting.
+func (c *Client) rateLimitWait(ctx context.Context) (err error) {
+	ctx, span := c.startSpan(ctx, "powerwall.rateLimitWait")
+	defer func() { endSpan(span, err) }()
+
 	c.rateLimitMutex.Lock()
 	defer c.rateLimitMutex.Unlock()
 
@@ -237,73 +333,121 @@ func (c *Client) rateLimitWait() {
 	if elapsed := time.Since(c.lastRequestTime); elapsed < interval {
 		waitTime := interval - elapsed
 		c.logf("Rate limiting: waiting %v before next request", waitTime)
-		time.Sleep(waitTime)
+		span.SetAttributes(attribute.Int64("powerwall.rate_limit.wait_ms", waitTime.Milliseconds()))
+
+		timer := time.NewTimer(waitTime)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 
 	c.lastRequestTime = time.Now()
+	return nil
 }
 
 // doFleetRequest performs an HTTP request to Tesla Fleet API with authentication and rate limiting
-func (c *Client) doFleetRequest(method, endpoint string, payload []byte) ([]byte, error) {
+func (c *Client) doFleetRequest(ctx context.Context, method, endpoint string, payload []byte) (body []byte, err error) {
+	ctx, span := c.startRequestSpan(ctx, method, endpoint)
+	defer func() { endSpan(span, err) }()
+
 	// Rate limiting
-	c.rateLimitWait()
+	if err := c.rateLimitWait(ctx); err != nil {
+		return nil, err
+	}
+
+	// Report the outcome to the request observer, logger and span.  Requests
+	// are sent once, so retries stays 0 until a retry path is added.
+	statusCode := 0
+	retries := 0
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		endRequestSpan(span, statusCode, retries)
+		c.logRequest(method, endpoint, statusCode, duration, retries, err)
+		if c.requestObserver != nil {
+			c.requestObserver(RequestInfo{
+				Method:     method,
+				Endpoint:   endpointTemplate(endpoint),
+				StatusCode: statusCode,
+				Duration:   duration,
+				Err:        err,
+			})
+		}
+	}()
 
 	// Check and refresh token if needed
 	if c.IsTokenExpired() {
 		c.logf("Access token expired, refreshing...")
-		err := c.RefreshToken()
+		err := c.RefreshTokenContext(ctx)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	body, statusCode, err = c.sendFleetRequest(ctx, method, endpoint, payload)
+	return body, err
+}
+
+// sendFleetRequest sends a single authenticated request and returns its body
+// and status code.  The status code is 0 if no response was received.
+func (c *Client) sendFleetRequest(ctx context.Context, method, endpoint string, payload []byte) (body []byte, statusCode int, err error) {
 	// Build URL
 	apiURL := FleetAPIBaseURL + endpoint
 
 	// Create request
 	var req *http.Request
-	var err error
 
 	if payload != nil {
-		req, err = http.NewRequest(method, apiURL, bytes.NewBuffer(payload))
+		req, err = http.NewRequestWithContext(ctx, method, apiURL, bytes.NewBuffer(payload))
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		req.Header.Set("Content-Type", "application/json")
 	} else {
-		req, err = http.NewRequest(method, apiURL, nil)
+		req, err = http.NewRequestWithContext(ctx, method, apiURL, nil)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 	}
 
 	// Add authorization header
 	req.Header.Set("Authorization", "Bearer "+c.accessToken)
-	req.Header.Set("User-Agent", "go-powerwall/v2.0")
+	req.Header.Set("User-Agent", c.userAgent)
 
 	c.logf("Fleet API request: method=%s url=%s", method, apiURL)
 
 	// Execute request
-	resp, err := c.httpClient.Do(req)
+	resp, cancel, err := c.send(req, endpoint)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
+	defer cancel()
 	defer resp.Body.Close()
+	statusCode = resp.StatusCode
+	c.observeServerTime(resp.Header, time.Now())
 
-	body, err := io.ReadAll(resp.Body)
+	body, err = io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, statusCode, err
 	}
 
 	// Handle various error conditions
 	switch resp.StatusCode {
 	case 200, 201:
 		c.logf("Fleet API request successful: status=%d", resp.StatusCode)
-		return body, nil
+		if method == http.MethodPost {
+			if err := commandResult(*req.URL, body); err != nil {
+				return nil, statusCode, err
+			}
+		}
+		return body, statusCode, nil
 
 	case 401:
 		c.logf("Fleet API authentication failed: status=%d body=%s", resp.StatusCode, string(body))
-		return nil, TokenExpiredError{
+		return nil, statusCode, TokenExpiredError{
 			Token:     "access",
 			ExpiresAt: c.tokenExpiry,
 		}
@@ -315,7 +459,7 @@ func (c *Client) doFleetRequest(method, endpoint string, payload []byte) ([]byte
 		if retryHeader := resp.Header.Get("Retry-After"); retryHeader != "" {
 			fmt.Sscanf(retryHeader, "%d", &retryAfter)
 		}
-		return nil, RateLimitError{
+		return nil, statusCode, RateLimitError{
 			Endpoint:   endpoint,
 			Limit:      c.rateLimitConfig.RealtimeDataRPM,
 			Remaining:  0,
@@ -325,17 +469,13 @@ func (c *Client) doFleetRequest(method, endpoint string, payload []byte) ([]byte
 
 	default:
 		c.logf("Fleet API request failed: status=%d body=%s", resp.StatusCode, string(body))
-		return nil, ApiError{
-			URL:        *req.URL,
-			StatusCode: resp.StatusCode,
-			Body:       body,
-		}
+		return nil, statusCode, newApiError(method, *req.URL, resp.StatusCode, body)
 	}
 }
 
 // apiGetJson performs a GET request and unmarshals JSON response
-func (c *Client) apiGetJson(endpoint string, result interface{}) error {
-	respData, err := c.doFleetRequest("GET", endpoint, nil)
+func (c *Client) apiGetJson(ctx context.Context, endpoint string, result interface{}) error {
+	respData, err := c.cachedGet(ctx, endpoint)
 	if err != nil {
 		return err
 	}
@@ -348,8 +488,11 @@ func (c *Client) apiGetJson(endpoint string, result interface{}) error {
 	return nil
 }
 
-// apiPostJson performs a POST request with JSON payload and unmarshals JSON response
-func (c *Client) apiPostJson(endpoint string, payload interface{}, result interface{}) error {
+// apiPostJson performs a POST request with JSON payload and unmarshals JSON
+// response.  Every POST is recorded in the audit sink, if one is registered,
+// with previous as the value of the setting before the request.  In dry-run
+// mode the request is only logged and audited, and result is left untouched.
+func (c *Client) apiPostJson(ctx context.Context, endpoint string, payload interface{}, result interface{}, previous string) error {
 	var payloadData []byte
 	var err error
 
@@ -360,7 +503,14 @@ func (c *Client) apiPostJson(endpoint string, payload interface{}, result interf
 		}
 	}
 
-	respData, err := c.doFleetRequest("POST", endpoint, payloadData)
+	if c.dryRun {
+		c.logf("Dry run: not sending POST %s %s", endpoint, payloadData)
+		c.audit(endpoint, payloadData, previous, nil)
+		return nil
+	}
+
+	respData, err := c.doFleetRequest(ctx, "POST", endpoint, payloadData)
+	c.audit(endpoint, payloadData, previous, err)
 	if err != nil {
 		return err
 	}
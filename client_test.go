@@ -0,0 +1,22 @@
+package powerwall
+
+import "testing"
+
+func TestEndpointTemplate(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{"/api/1/products", "/api/1/products"},
+		{"/api/1/energy_sites/2252147418962514/live_status", "/api/1/energy_sites/{id}/live_status"},
+		{
+			"/api/1/energy_sites/2252147418962514/calendar_history?end_date=2026-10-18T00%3A00%3A00Z&kind=energy&period=day",
+			"/api/1/energy_sites/{id}/calendar_history",
+		},
+	}
+	for _, tt := range tests {
+		if got := endpointTemplate(tt.endpoint); got != tt.want {
+			t.Errorf("endpointTemplate(%q) = %q, want %q", tt.endpoint, got, tt.want)
+		}
+	}
+}
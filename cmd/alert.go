@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/blampe/powerwall"
+	"github.com/blampe/powerwall/alert"
+	"github.com/blampe/powerwall/metrics"
+)
+
+// runAlerts evaluates the alert rules in configPath against the given sites
+// (or all sites on the account if none are given) until interrupted.
+func runAlerts(client *powerwall.Client, configPath string, siteIDs []int64) error {
+	config, err := alert.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	engine, err := alert.NewFromConfig(config,
+		alert.WithErrorHandler(func(err error) { logError("Alerting failed", err) }),
+	)
+	if err != nil {
+		return err
+	}
+
+	poller := metrics.NewPoller([]metrics.Sink{engine},
+		metrics.WithSites(siteIDs...),
+		metrics.WithInterval(options.Interval),
+		metrics.WithErrorHandler(func(err error) { logError("Polling failed", err) }),
+	)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Fprintf(os.Stderr, "Evaluating %d alert rules from %s\n", len(config.Rules), configPath)
+	err = poller.Run(ctx, client)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/blampe/powerwall"
+	"github.com/blampe/powerwall/reconcile"
+	log "github.com/sirupsen/logrus"
+)
+
+// runApply compares the desired state in path with the sites' current
+// configuration and prints the plan.  With apply set, it then issues the
+// commands needed to reach the desired state.
+func runApply(client *powerwall.Client, path string, apply bool) error {
+	config, err := reconcile.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	plan, err := reconcile.NewPlan(client, config)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(plan)
+	if !apply || plan.Changes() == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(os.Stderr)
+	err = plan.Apply(client, func(c reconcile.Change, err error) {
+		fields := log.Fields{
+			"site":    c.SiteID,
+			"setting": c.Setting,
+			"from":    c.From,
+			"to":      c.To,
+		}
+		if err != nil {
+			log.WithFields(fields).WithField("err", err).Error("Change failed")
+			return
+		}
+		log.WithFields(fields).Info("Applied change")
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Apply complete: %d change(s) applied.\n", plan.Changes())
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/blampe/powerwall"
+	"github.com/blampe/powerwall/export"
+)
+
+// writeHistory writes a history result to stdout in the format selected with
+// --format.
+func writeHistory(history *powerwall.HistoryData) {
+	if options.Format == "" || options.Format == "json" {
+		writeResult(history)
+		return
+	}
+
+	w, err := export.NewWriter(options.Format, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(3)
+	}
+	if err := w.Write(history.TimeSeries); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+}
+
+// exportHistory streams calendar history of the given kind between two
+// YYYY-MM-DD dates (in local time, end exclusive) to a file.
+func exportHistory(client *powerwall.Client, kind, period, startDate, endDate, path string) error {
+	start, err := time.ParseInLocation("2006-01-02", startDate, time.Local)
+	if err != nil {
+		return fmt.Errorf("invalid start date %q: %w", startDate, err)
+	}
+	end, err := time.ParseInLocation("2006-01-02", endDate, time.Local)
+	if err != nil {
+		return fmt.Errorf("invalid end date %q: %w", endDate, err)
+	}
+
+	format := options.Format
+	if format == "" || format == "json" {
+		// JSON doesn't stream; pick the format from the file name instead
+		format = strings.TrimPrefix(filepath.Ext(path), ".")
+	}
+
+	if !slices.Contains(export.Formats, format) {
+		return fmt.Errorf("unsupported export format: %s (supported: %s)", format, strings.Join(export.Formats, ", "))
+	}
+
+	// Export to a temporary file next to path, so a failed export never
+	// leaves a truncated file under the requested name
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := f.Chmod(0o644); err != nil {
+		return err
+	}
+
+	w, err := export.NewWriter(format, f)
+	if err != nil {
+		return err
+	}
+
+	err = export.Range(client, w, kind, period, start, end, func(next time.Time) {
+		fmt.Fprintf(os.Stderr, "Exported %s history up to %s\n", kind, next.Format("2006-01-02"))
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+
+	"github.com/blampe/powerwall"
+	"github.com/blampe/powerwall/firmware"
+	"github.com/blampe/powerwall/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// runFirmware records the versions running at the given sites (or all sites
+// on the account if none are given) in --firmware-store.  With watch set it
+// keeps checking every hour until interrupted and logs each update;
+// otherwise it checks once and prints the version history of every site.
+func runFirmware(client *powerwall.Client, watch bool, siteIDs []int64) error {
+	store, err := firmware.OpenStore(options.FirmwareStore)
+	if err != nil {
+		return err
+	}
+
+	tracker := firmware.NewTracker(client, store,
+		firmware.WithSites(siteIDs...),
+		firmware.WithUpdateHandler(logFirmwareUpdate),
+		firmware.WithErrorHandler(func(err error) { logError("Firmware check failed", err) }),
+	)
+
+	if watch {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		fmt.Fprintf(os.Stderr, "Tracking firmware versions in %s\n", options.FirmwareStore)
+		err = tracker.Run(ctx)
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return err
+	}
+
+	updates, err := tracker.Check()
+	for _, u := range updates {
+		logFirmwareUpdate(u)
+	}
+	if err != nil {
+		return err
+	}
+
+	sites, err := metrics.ResolveSites(client, siteIDs)
+	if err != nil {
+		return err
+	}
+	if options.Format == "json" {
+		history := map[int64][]firmware.Record{}
+		for _, site := range sites {
+			history[site.ID] = store.History(site.ID)
+		}
+		writeResult(history)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SITE\tCOMPONENT\tVERSION\tFIRST_SEEN\tLAST_SEEN")
+	for _, site := range sites {
+		for _, r := range store.History(site.ID) {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", site.Name, r.Component, r.Version,
+				r.FirstSeen.Format("2006-01-02 15:04"), r.LastSeen.Format("2006-01-02 15:04"))
+		}
+	}
+	return w.Flush()
+}
+
+// logFirmwareUpdate logs a version change
+func logFirmwareUpdate(u firmware.Update) {
+	log.WithFields(log.Fields{
+		"site":      u.Site.ID,
+		"component": u.Component,
+		"from":      u.From,
+		"to":        u.To,
+	}).Info(u.String())
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/blampe/powerwall"
+	"github.com/blampe/powerwall/forecast"
+)
+
+// backtestDays is the number of most recent days held out by forecast backtest.
+const backtestDays = 7
+
+// runForecast prints a day-ahead load and solar forecast for the selected
+// site built from --history-days of power history, or with backtest set,
+// scores such forecasts against the most recent week.
+func runForecast(client *powerwall.Client, backtest bool) error {
+	info, err := client.GetSiteInfo()
+	if err != nil {
+		return err
+	}
+	loc, err := time.LoadLocation(info.TimeZone)
+	if err != nil {
+		return fmt.Errorf("loading installation time zone: %w", err)
+	}
+
+	now := time.Now()
+	fmt.Fprintf(os.Stderr, "Fetching %d days of power history...\n", options.HistoryDays)
+	observations, err := forecast.Fetch(client, now.AddDate(0, 0, -options.HistoryDays), now, loc)
+	if err != nil {
+		return err
+	}
+
+	if backtest {
+		score, err := forecast.Backtest(observations, loc, backtestDays)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "Backtest over %d days (kWh per %s slot)\n\n", score.Days, forecast.SlotDuration)
+		fmt.Fprintln(w, "\tMAE\tRMSE\tBIAS\tCOVERAGE")
+		for _, row := range []struct {
+			name string
+			m    forecast.Metrics
+		}{{"load", score.Load}, {"solar", score.Solar}} {
+			fmt.Fprintf(w, "%s\t%.3f\t%.3f\t%+.3f\t%.0f%%\n", row.name, row.m.MAE, row.m.RMSE, row.m.Bias, row.m.Coverage*100)
+		}
+		return w.Flush()
+	}
+
+	tomorrow := time.Date(now.In(loc).Year(), now.In(loc).Month(), now.In(loc).Day()+1, 0, 0, 0, 0, loc)
+	slots := int(tomorrow.AddDate(0, 0, 1).Sub(tomorrow) / forecast.SlotDuration)
+	intervals, err := forecast.New(observations, loc).Forecast(tomorrow, slots)
+	if err != nil {
+		return err
+	}
+
+	if options.Format == "json" {
+		writeResult(intervals)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tLOAD_KWH\tLOAD_RANGE\tSOLAR_KWH\tSOLAR_RANGE")
+	var load, solar float64
+	for _, interval := range intervals {
+		fmt.Fprintf(w, "%s\t%.2f\t%.2f-%.2f\t%.2f\t%.2f-%.2f\n", interval.Start.Format("Mon 15:04"),
+			interval.Load.Expected, interval.Load.Low, interval.Load.High,
+			interval.Solar.Expected, interval.Solar.Low, interval.Solar.High)
+		load += interval.Load.Expected
+		solar += interval.Solar.Expected
+	}
+	fmt.Fprintf(w, "TOTAL\t%.1f\t\t%.1f\t\n", load, solar)
+	return w.Flush()
+}
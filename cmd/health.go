@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/blampe/powerwall"
+	"github.com/blampe/powerwall/health"
+	"github.com/blampe/powerwall/metrics"
+)
+
+// runHealth samples the usable capacity of the given sites (or all sites on
+// the account if none are given) into --health-store and prints a
+// degradation report for each from the samples collected so far.  With watch
+// set it samples once a day until interrupted instead.
+func runHealth(client *powerwall.Client, watch bool, siteIDs []int64) error {
+	store, err := health.OpenStore(options.HealthStore)
+	if err != nil {
+		return err
+	}
+
+	sampler := health.NewSampler(client, store,
+		health.WithSites(siteIDs...),
+		health.WithErrorHandler(func(err error) { logError("Capacity sample failed", err) }),
+	)
+
+	if watch {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		fmt.Fprintf(os.Stderr, "Sampling battery capacity daily into %s\n", options.HealthStore)
+		err = sampler.Run(ctx)
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return err
+	}
+
+	if err := sampler.Sample(); err != nil {
+		return err
+	}
+
+	sites, err := metrics.ResolveSites(client, siteIDs)
+	if err != nil {
+		return err
+	}
+
+	reports := map[int64]*health.Report{}
+	for _, site := range sites {
+		report, err := health.Analyze(store.Samples(site.ID),
+			health.WithWarrantyThreshold(options.Warranty/100))
+		if err != nil {
+			return fmt.Errorf("site %d: %w", site.ID, err)
+		}
+		reports[site.ID] = report
+	}
+
+	if options.Format == "json" {
+		writeResult(reports)
+		return nil
+	}
+
+	for i, site := range sites {
+		if i > 0 {
+			fmt.Println()
+		}
+		printHealthReport(site, reports[site.ID])
+	}
+	return nil
+}
+
+// printHealthReport prints a degradation report for humans
+func printHealthReport(site metrics.Site, r *health.Report) {
+	fmt.Printf("%s (%d)\n", site.Name, site.ID)
+	fmt.Printf("  Capacity:   %.1f of %.1f kWh nameplate (%d × %.1f kWh)\n",
+		r.CapacityWh/1000, r.NameplateWh/1000, r.BatteryCount, r.NominalCapacityWh/1000)
+	fmt.Printf("  Retained:   %.1f%% (%.1f%% fade)\n", r.Retained*100, r.Fade*100)
+	fmt.Printf("  Samples:    %d from %s to %s\n", r.Samples, r.First.Format("2006-01-02"), r.Last.Format("2006-01-02"))
+	if r.Trend == nil {
+		fmt.Printf("  Trend:      not enough samples yet; run health daily or use 'health watch'\n")
+		return
+	}
+	fmt.Printf("  Trend:      %+.2f%% of nameplate per year\n", -r.Trend.FadePerYear*100)
+	if r.ThresholdDate != nil {
+		fmt.Printf("  Projected:  %.0f%% warranty threshold reached around %s\n", r.Threshold*100, r.ThresholdDate.Format("2006-01"))
+	} else if r.Trend.SlopeWhPerYear < 0 {
+		fmt.Printf("  Projected:  %.0f%% warranty threshold more than %d years away\n", r.Threshold*100, health.MaxProjectionYears)
+	} else {
+		fmt.Printf("  Projected:  capacity isn't trending down\n")
+	}
+}
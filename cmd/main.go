@@ -17,6 +17,16 @@
 //	go run ./cmd/main.go products      # List energy sites
 //	go run ./cmd/main.go status        # Real-time system status
 //	go run ./cmd/main.go aggregates    # Power flow data
+//	go run ./cmd/main.go watch         # Live-updating power flow view
+//	go run ./cmd/main.go serve-metrics # Prometheus exporter on :9771
+//	go run ./cmd/main.go mqtt-bridge   # Home Assistant MQTT bridge
+//	go run ./cmd/main.go alert alerts.yaml # Grid outage and battery alerts
+//	go run ./cmd/main.go schedule schedule.yaml # Timed reserve/mode changes
+//	go run ./cmd/main.go apply -f sites.yaml    # Declarative site configuration
+//	go run ./cmd/main.go optimize      # Plan reserve changes for the next 24h
+//	go run ./cmd/main.go forecast      # Day-ahead load and solar forecast
+//	go run ./cmd/main.go firmware watch # Log firmware updates across sites
+//	go run ./cmd/main.go health        # Battery capacity fade report
 //
 // This is mainly intended as a simple way to test the library functions and as an example of use.
 package main
@@ -27,18 +37,42 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/blampe/powerwall"
+	"github.com/blampe/powerwall/metrics"
 	"github.com/jessevdk/go-flags"
 	log "github.com/sirupsen/logrus"
 )
 
 var options struct {
-	Debug  bool  `long:"debug" description:"Enable debug messages"`
-	SiteID int64 `long:"site-id" description:"Energy site ID (overrides SITE_ID env var)"`
-	Args   struct {
-		Command string   `positional-arg-name:"command" description:"Available commands: 'products', 'status', 'site_info', 'aggregates', 'soe', 'grid_status', 'telemetry_history', 'energy_history', 'backup_history', 'calendar_history', 'set_backup_reserve', 'set_storm_mode', 'set_site_name', 'operation', 'system_status', 'sitemaster', 'networks', 'grid_faults', 'meters'"`
-		Args    []string `positional-arg-name:"args" description:"Arguments for command (start_date end_date [period] [timezone] for history commands, percentage for backup reserve)"`
+	Debug         bool          `long:"debug" description:"Enable debug messages"`
+	SiteID        int64         `long:"site-id" description:"Energy site ID (overrides SITE_ID env var)"`
+	Format        string        `long:"format" choice:"json" choice:"csv" choice:"jsonl" choice:"table" choice:"parquet" description:"Output format for history commands (default json; export infers it from the file extension)"`
+	ListenAddr    string        `long:"listen" default:":9771" description:"Listen address for serve-metrics"`
+	Interval      time.Duration `long:"interval" default:"1m" description:"Polling interval for serve-metrics, mqtt-bridge, watch and alert"`
+	SOEThresholds []float64     `long:"soe-threshold" default:"20" description:"Battery charge percentage that triggers a watch event when crossed (may be repeated)"`
+	History       time.Duration `long:"history-interval" description:"How often serve-metrics writes today's energy history to the Influx/OTLP sinks (0 to disable)"`
+	InfluxURL     string        `long:"influx-url" description:"InfluxDB write URL for serve-metrics, including org/bucket or db query parameters"`
+	InfluxToken   string        `long:"influx-token" env:"INFLUX_TOKEN" description:"InfluxDB API token for serve-metrics"`
+	OTLPEndpoint  string        `long:"otlp-endpoint" description:"OTLP/HTTP metrics endpoint for serve-metrics, e.g. http://localhost:4318/v1/metrics"`
+	MQTTBroker    string        `long:"mqtt-broker" default:"tcp://localhost:1883" description:"MQTT broker URL for mqtt-bridge"`
+	MQTTUsername  string        `long:"mqtt-username" env:"MQTT_USERNAME" description:"MQTT username for mqtt-bridge"`
+	MQTTPassword  string        `long:"mqtt-password" env:"MQTT_PASSWORD" description:"MQTT password for mqtt-bridge"`
+	MQTTPrefix    string        `long:"mqtt-prefix" default:"powerwall" description:"MQTT topic prefix for mqtt-bridge"`
+	Tariff        string        `long:"tariff" description:"YAML tariff file for optimize (defaults to the site's rate plan)"`
+	MinReserve    int           `long:"min-reserve" default:"20" description:"Lowest backup reserve percentage optimize may plan"`
+	HistoryDays   int           `long:"history-days" default:"28" description:"Days of history used by forecast and optimize"`
+	FirmwareStore string        `long:"firmware-store" default:"firmware.json" description:"File where firmware records the versions seen at each site"`
+	HealthStore   string        `long:"health-store" default:"health.json" description:"File where health records daily battery capacity samples"`
+	File          string        `short:"f" long:"file" description:"Desired-state YAML file for plan and apply"`
+	DryRun        bool          `long:"dry-run" description:"Log control commands instead of sending them"`
+	AuditLog      string        `long:"audit-log" description:"Append a JSON record of every control command to this file"`
+	VerifyTimeout time.Duration `long:"verify-timeout" description:"Read settings back after control commands until the change is observed, failing after this long (0 to disable)"`
+	Warranty      float64       `long:"warranty-threshold" default:"70" description:"Warranted capacity percentage projected by health"`
+	Args          struct {
+		Command string   `positional-arg-name:"command" description:"Available commands: 'products', 'status', 'site_info', 'site_config', 'aggregates', 'soe', 'grid_status', 'backup_runtime', 'telemetry_history', 'energy_history', 'backup_history', 'power_history', 'calendar_history', 'export', 'set_backup_reserve', 'set_storm_mode', 'set_operation_mode', 'set_site_name', 'operation', 'system_status', 'sitemaster', 'networks', 'grid_faults', 'meters', 'serve-metrics', 'mqtt-bridge', 'watch', 'alert', 'schedule', 'optimize', 'forecast', 'firmware', 'health', 'plan', 'apply'"`
+		Args    []string `positional-arg-name:"args" description:"Arguments for command (start_date end_date [period] [timezone] for history commands, kind period start_date end_date file for export, percentage for backup reserve, site IDs for serve-metrics, config file and site IDs for alert, config file for schedule, 'execute' for optimize, 'backtest' for forecast, 'watch' and site IDs for firmware and health, desired-state file for plan and apply)"`
 	} `positional-args:"true" required:"true"`
 }
 
@@ -82,9 +116,6 @@ func main() {
 		os.Exit(2)
 	}
 
-	// Create Fleet API client
-	client := powerwall.NewClient(clientID, accessToken, refreshToken)
-
 	// Determine site ID
 	siteID := options.SiteID
 	if siteID == 0 {
@@ -97,6 +128,37 @@ func main() {
 		}
 	}
 
+	configuredSiteID := siteID
+
+	// The metrics exporter needs to observe every request the client makes
+	var clientOptions []func(c *powerwall.Client)
+	var exporter *metrics.Exporter
+	if options.Args.Command == "serve-metrics" {
+		exporter = metrics.NewExporter()
+		clientOptions = append(clientOptions, powerwall.WithRequestObserver(exporter.ObserveRequest))
+	}
+	if options.VerifyTimeout > 0 {
+		clientOptions = append(clientOptions, powerwall.WithCommandVerification(options.VerifyTimeout))
+	}
+	if options.DryRun {
+		clientOptions = append(clientOptions, powerwall.WithDryRun())
+	}
+	if options.AuditLog != "" {
+		auditLog, err := powerwall.OpenAuditLog(options.AuditLog)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening audit log: %s\n", err)
+			os.Exit(2)
+		}
+		defer auditLog.Close()
+		clientOptions = append(clientOptions, powerwall.WithAuditSink(auditLog))
+	}
+
+	// Create Fleet API client
+	client := powerwall.NewClient(clientID, accessToken, refreshToken, clientOptions...)
+	if options.DryRun {
+		fmt.Fprintf(os.Stderr, "Dry run: control commands will be logged but not sent\n")
+	}
+
 	// Auto-select site if not specified
 	if siteID == 0 {
 		products, err := client.GetEnergyProducts()
@@ -143,6 +205,17 @@ func main() {
 		}
 		writeResult(result)
 
+	case "site_config":
+		result, err := client.GetSiteConfig()
+		if err != nil {
+			handleError(err)
+		}
+		if options.Format == "json" {
+			writeResult(result)
+		} else if err := printSiteConfig(result); err != nil {
+			handleError(err)
+		}
+
 	case "aggregates":
 		result, err := client.GetMetersAggregates()
 		if err != nil {
@@ -164,6 +237,17 @@ func main() {
 		}
 		writeResult(result)
 
+	case "backup_runtime":
+		result, err := client.EstimateBackupRuntime()
+		if err != nil {
+			handleError(err)
+		}
+		if options.Format == "json" {
+			writeResult(result)
+		} else {
+			printBackupRuntime(result)
+		}
+
 	case "telemetry_history":
 		if len(options.Args.Args) < 2 {
 			fmt.Fprintf(os.Stderr, "Error: telemetry_history requires start_date and end_date arguments\n")
@@ -180,7 +264,7 @@ func main() {
 		if err != nil {
 			handleError(err)
 		}
-		writeResult(result)
+		writeHistory(result)
 
 	case "energy_history":
 		if len(options.Args.Args) < 3 {
@@ -199,7 +283,7 @@ func main() {
 		if err != nil {
 			handleError(err)
 		}
-		writeResult(result)
+		writeHistory(result)
 
 	case "backup_history":
 		if len(options.Args.Args) < 3 {
@@ -218,7 +302,26 @@ func main() {
 		if err != nil {
 			handleError(err)
 		}
-		writeResult(result)
+		writeHistory(result)
+
+	case "power_history":
+		if len(options.Args.Args) < 3 {
+			fmt.Fprintf(os.Stderr, "Error: power_history requires start_date, end_date, and period arguments\n")
+			fmt.Fprintf(os.Stderr, "Example: power_history 2023-12-01 2023-12-01 day\n")
+			os.Exit(3)
+		}
+		startDate := options.Args.Args[0]
+		endDate := options.Args.Args[1]
+		period := options.Args.Args[2]
+		var timeZone string
+		if len(options.Args.Args) > 3 {
+			timeZone = options.Args.Args[3]
+		}
+		result, err := client.GetPowerHistory(startDate, endDate, period, timeZone)
+		if err != nil {
+			handleError(err)
+		}
+		writeHistory(result)
 
 	case "calendar_history":
 		if len(options.Args.Args) < 4 {
@@ -238,7 +341,19 @@ func main() {
 		if err != nil {
 			handleError(err)
 		}
-		writeResult(result)
+		writeHistory(result)
+
+	case "export":
+		if len(options.Args.Args) < 5 {
+			fmt.Fprintf(os.Stderr, "Error: export requires kind, period, start_date, end_date, and file arguments\n")
+			fmt.Fprintf(os.Stderr, "Example: --format csv export power day 2023-01-01 2024-01-01 power.csv\n")
+			os.Exit(3)
+		}
+		err = exportHistory(client, options.Args.Args[0], options.Args.Args[1],
+			options.Args.Args[2], options.Args.Args[3], options.Args.Args[4])
+		if err != nil {
+			handleError(err)
+		}
 
 	case "set_backup_reserve":
 		if len(options.Args.Args) == 0 {
@@ -272,6 +387,18 @@ func main() {
 		}
 		fmt.Printf("Storm mode %s\n", status)
 
+	case "set_operation_mode":
+		if len(options.Args.Args) == 0 {
+			fmt.Fprintf(os.Stderr, "Error: set_operation_mode requires mode argument (self_consumption, autonomous, backup)\n")
+			os.Exit(3)
+		}
+		mode := options.Args.Args[0]
+		err = client.SetOperationMode(mode)
+		if err != nil {
+			handleError(err)
+		}
+		fmt.Printf("Operation mode set to %s\n", mode)
+
 	case "set_site_name":
 		if len(options.Args.Args) == 0 {
 			fmt.Fprintf(os.Stderr, "Error: set_site_name requires name argument\n")
@@ -331,6 +458,110 @@ func main() {
 		}
 		writeResult(result)
 
+	case "serve-metrics":
+		siteIDs := parseSiteIDs(options.Args.Args)
+		if len(siteIDs) == 0 && configuredSiteID != 0 {
+			siteIDs = append(siteIDs, configuredSiteID)
+		}
+		err = serveMetrics(client, exporter, siteIDs)
+		if err != nil {
+			handleError(err)
+		}
+
+	case "watch":
+		watch(client)
+
+	case "mqtt-bridge":
+		err = runMQTTBridge(client)
+		if err != nil {
+			handleError(err)
+		}
+
+	case "alert":
+		if len(options.Args.Args) == 0 {
+			fmt.Fprintf(os.Stderr, "Error: alert requires a config file argument\n")
+			fmt.Fprintf(os.Stderr, "Example: alert alerts.yaml\n")
+			os.Exit(3)
+		}
+		siteIDs := parseSiteIDs(options.Args.Args[1:])
+		if len(siteIDs) == 0 && configuredSiteID != 0 {
+			siteIDs = append(siteIDs, configuredSiteID)
+		}
+		err = runAlerts(client, options.Args.Args[0], siteIDs)
+		if err != nil {
+			handleError(err)
+		}
+
+	case "optimize":
+		execute := len(options.Args.Args) > 0 && options.Args.Args[0] == "execute"
+		err = optimize(client, execute)
+		if err != nil {
+			handleError(err)
+		}
+
+	case "forecast":
+		backtest := len(options.Args.Args) > 0 && options.Args.Args[0] == "backtest"
+		err = runForecast(client, backtest)
+		if err != nil {
+			handleError(err)
+		}
+
+	case "firmware":
+		args := options.Args.Args
+		watch := len(args) > 0 && args[0] == "watch"
+		if watch {
+			args = args[1:]
+		}
+		siteIDs := parseSiteIDs(args)
+		if len(siteIDs) == 0 && configuredSiteID != 0 {
+			siteIDs = append(siteIDs, configuredSiteID)
+		}
+		err = runFirmware(client, watch, siteIDs)
+		if err != nil {
+			handleError(err)
+		}
+
+	case "health":
+		args := options.Args.Args
+		watch := len(args) > 0 && args[0] == "watch"
+		if watch {
+			args = args[1:]
+		}
+		siteIDs := parseSiteIDs(args)
+		if len(siteIDs) == 0 && configuredSiteID != 0 {
+			siteIDs = append(siteIDs, configuredSiteID)
+		}
+		err = runHealth(client, watch, siteIDs)
+		if err != nil {
+			handleError(err)
+		}
+
+	case "plan", "apply":
+		path := options.File
+		if path == "" && len(options.Args.Args) > 0 {
+			path = options.Args.Args[0]
+		}
+		if path == "" {
+			fmt.Fprintf(os.Stderr, "Error: %s requires a desired-state file\n", options.Args.Command)
+			fmt.Fprintf(os.Stderr, "Example: %s -f site.yaml\n", options.Args.Command)
+			os.Exit(3)
+		}
+		err = runApply(client, path, options.Args.Command == "apply")
+		if err != nil {
+			handleError(err)
+		}
+
+	case "schedule":
+		if len(options.Args.Args) == 0 {
+			fmt.Fprintf(os.Stderr, "Error: schedule requires a config file argument\n")
+			fmt.Fprintf(os.Stderr, "Example: schedule schedule.yaml\n")
+			os.Exit(3)
+		}
+		err = runSchedule(client, options.Args.Args[0])
+		if err != nil {
+			handleError(err)
+		}
+
 	default:
 		fmt.Fprintf(os.Stderr, "Error: Unknown command: %v\n", options.Args.Command)
 		fmt.Fprintf(os.Stderr, "\nAvailable commands:\n")
@@ -338,23 +569,54 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  products                      - List energy products/sites\n")
 		fmt.Fprintf(os.Stderr, "  status                        - Real-time system status\n")
 		fmt.Fprintf(os.Stderr, "  site_info                     - Site configuration\n")
+		fmt.Fprintf(os.Stderr, "  site_config                   - Components, gateways, firmware and reserves\n")
 		fmt.Fprintf(os.Stderr, "  aggregates                    - Power flow data\n")
 		fmt.Fprintf(os.Stderr, "  soe                           - Battery state of energy\n")
 		fmt.Fprintf(os.Stderr, "  grid_status                   - Grid connection status\n")
+		fmt.Fprintf(os.Stderr, "  backup_runtime                - Estimated hours of autonomy in an outage\n")
 		fmt.Fprintf(os.Stderr, "\nHistorical Data:\n")
 		fmt.Fprintf(os.Stderr, "  power_history [period]        - Power history (day,week)\n")
 		fmt.Fprintf(os.Stderr, "  energy_history [period]       - Energy history (day,week,month,year,lifetime)\n")
 		fmt.Fprintf(os.Stderr, "  calendar_history <date> <period> - Historical data by date (YYYY-MM-DD)\n")
+		fmt.Fprintf(os.Stderr, "  forecast [backtest]           - Day-ahead load/solar forecast, or score it on the last week\n")
+		fmt.Fprintf(os.Stderr, "  export <kind> <period> <start> <end> <file> - Stream a long date range to a file\n")
+		fmt.Fprintf(os.Stderr, "  (history commands accept --format csv|jsonl|table|parquet)\n")
 		fmt.Fprintf(os.Stderr, "\nControl Commands:\n")
 		fmt.Fprintf(os.Stderr, "  set_backup_reserve <percent>  - Set backup reserve percentage (0-100)\n")
 		fmt.Fprintf(os.Stderr, "  set_storm_mode <true|false>   - Enable/disable Storm Watch\n")
+		fmt.Fprintf(os.Stderr, "  set_operation_mode <mode>     - Set operation mode (self_consumption, autonomous, backup)\n")
 		fmt.Fprintf(os.Stderr, "  set_site_name <name>          - Set site display name\n")
+		fmt.Fprintf(os.Stderr, "  schedule <config>             - Apply reserve/mode/Storm Watch changes on cron schedules\n")
+		fmt.Fprintf(os.Stderr, "  plan -f <file>                - Show changes needed to reach a declared site configuration\n")
+		fmt.Fprintf(os.Stderr, "  apply -f <file>               - Make only the changes needed to reach it\n")
+		fmt.Fprintf(os.Stderr, "  optimize [execute]            - Plan (and optionally apply) 24h of tariff-aware reserve changes\n")
+		fmt.Fprintf(os.Stderr, "\nMonitoring:\n")
+		fmt.Fprintf(os.Stderr, "  watch                         - Live-updating power-flow view (--interval, --soe-threshold)\n")
+		fmt.Fprintf(os.Stderr, "  serve-metrics [site_id...]    - Serve Prometheus metrics, optionally pushing to InfluxDB/OTLP\n")
+		fmt.Fprintf(os.Stderr, "  mqtt-bridge                   - Publish to MQTT with Home Assistant discovery (--mqtt-broker)\n")
+		fmt.Fprintf(os.Stderr, "  alert <config> [site_id...]   - Evaluate alert rules and send notifications\n")
+		fmt.Fprintf(os.Stderr, "  firmware [watch] [site_id...] - Record firmware versions and report updates (--firmware-store)\n")
+		fmt.Fprintf(os.Stderr, "  health [watch] [site_id...]   - Sample battery capacity and report fade (--health-store)\n")
 		fmt.Fprintf(os.Stderr, "\nUnsupported (will show errors):\n")
 		fmt.Fprintf(os.Stderr, "  operation, system_status, sitemaster, networks, grid_faults, meters\n")
 		os.Exit(3)
 	}
 }
 
+// parseSiteIDs parses energy site IDs given as command arguments
+func parseSiteIDs(args []string) []int64 {
+	var siteIDs []int64
+	for _, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid site ID: %s\n", arg)
+			os.Exit(3)
+		}
+		siteIDs = append(siteIDs, id)
+	}
+	return siteIDs
+}
+
 func handleError(err error) {
 	// Handle different error types with appropriate messages
 	switch e := err.(type) {
@@ -368,12 +630,55 @@ func handleError(err error) {
 	case powerwall.RateLimitError:
 		fmt.Fprintf(os.Stderr, "Rate limit exceeded: %s\n", e.Error())
 		os.Exit(6)
+	case powerwall.SiteOfflineError:
+		fmt.Fprintf(os.Stderr, "Energy site offline: %s\n", e.ApiError.Error())
+		os.Exit(7)
+	case powerwall.StaleDataError:
+		fmt.Fprintf(os.Stderr, "Energy site offline: %s\n", e.Error())
+		os.Exit(7)
+	case powerwall.CommandNotAppliedError:
+		fmt.Fprintf(os.Stderr, "Command accepted but not applied: %s\n", e.Error())
+		os.Exit(9)
+	case powerwall.ForbiddenError:
+		fmt.Fprintf(os.Stderr, "Forbidden: %s\n", e.ApiError.Error())
+		if e.ScopeMissing() {
+			fmt.Fprintf(os.Stderr, "Grant the missing scope to your Fleet API application and re-authorize\n")
+		}
+		os.Exit(8)
 	default:
 		fmt.Fprintf(os.Stderr, "API error: %s\n", err.Error())
 		os.Exit(1)
 	}
 }
 
+// printBackupRuntime prints a backup runtime estimate for humans
+func printBackupRuntime(r *powerwall.BackupRuntime) {
+	fmt.Printf("Energy left:    %.1f of %.1f kWh\n", r.EnergyLeftWh/1000, r.TotalPackEnergy/1000)
+	if r.LoadWindow > 0 {
+		fmt.Printf("Average load:   %.0f W over the last %s\n", r.AverageLoadW, r.LoadWindow)
+	}
+	fmt.Printf("Current load:   %.0f W\n", r.CurrentLoadW)
+	fmt.Printf("Backup runtime: %s at average load", formatHours(r.Hours))
+	if r.CurrentLoadHours > 0 {
+		fmt.Printf(", %s at current load", formatHours(r.CurrentLoadHours))
+	}
+	fmt.Println()
+	if r.ReservePercent != nil {
+		fmt.Printf("From the %d%% backup reserve: %s\n", *r.ReservePercent, formatHours(*r.ReserveHours))
+	}
+}
+
+// formatHours formats a number of hours as e.g. "1d 4h 30m"
+func formatHours(hours float64) string {
+	d := time.Duration(hours * float64(time.Hour)).Round(time.Minute)
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	if days > 0 {
+		return fmt.Sprintf("%dd %dh %dm", days, d/time.Hour, (d%time.Hour)/time.Minute)
+	}
+	return fmt.Sprintf("%dh %dm", d/time.Hour, (d%time.Hour)/time.Minute)
+}
+
 func writeResult(value interface{}) {
 	b, err := json.MarshalIndent(value, "", "    ")
 	if err != nil {
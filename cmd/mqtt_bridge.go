@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/blampe/powerwall"
+	"github.com/blampe/powerwall/mqttbridge"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// runMQTTBridge connects to the MQTT broker and bridges the selected site
+// until interrupted.
+func runMQTTBridge(client *powerwall.Client) error {
+	info, err := client.GetSiteInfo()
+	if err != nil {
+		return err
+	}
+
+	availability := mqttbridge.AvailabilityTopic(options.MQTTPrefix, client.GetSelectedEnergySite())
+
+	mqttOptions := mqtt.NewClientOptions().
+		AddBroker(options.MQTTBroker).
+		SetClientID(fmt.Sprintf("powerwall-%d", client.GetSelectedEnergySite())).
+		SetUsername(options.MQTTUsername).
+		SetPassword(options.MQTTPassword).
+		SetWill(availability, "offline", 1, true).
+		SetAutoReconnect(true)
+
+	mqttClient := mqtt.NewClient(mqttOptions)
+	token := mqttClient.Connect()
+	if !token.WaitTimeout(30 * time.Second) {
+		return fmt.Errorf("timed out connecting to MQTT broker %s", options.MQTTBroker)
+	}
+	if err := token.Error(); err != nil {
+		return err
+	}
+	defer mqttClient.Disconnect(1000)
+
+	bridge := mqttbridge.New(client, mqttClient,
+		mqttbridge.WithTopicPrefix(options.MQTTPrefix),
+		mqttbridge.WithSiteName(info.SiteName),
+		mqttbridge.WithInterval(options.Interval),
+		mqttbridge.WithErrorHandler(func(err error) { logError("MQTT bridge error", err) }),
+	)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Fprintf(os.Stderr, "Bridging energy site %d to %s\n", client.GetSelectedEnergySite(), options.MQTTBroker)
+	err = bridge.Run(ctx)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/blampe/powerwall"
+	"github.com/blampe/powerwall/forecast"
+	"github.com/blampe/powerwall/optimizer"
+	log "github.com/sirupsen/logrus"
+)
+
+// optimize plans the next 24 hours of reserve changes for the selected site
+// and prints the plan.  If execute is true, the plan's commands are then
+// applied as they come due.
+func optimize(client *powerwall.Client, execute bool) error {
+	info, err := client.GetSiteInfo()
+	if err != nil {
+		return err
+	}
+	loc, err := time.LoadLocation(info.TimeZone)
+	if err != nil {
+		return fmt.Errorf("loading installation time zone: %w", err)
+	}
+
+	var tariff *optimizer.Tariff
+	if options.Tariff != "" {
+		tariff, err = optimizer.LoadTariff(options.Tariff)
+	} else {
+		var content map[string]interface{}
+		content, err = client.GetTariffContent()
+		if err == nil {
+			tariff, err = optimizer.DecodeTariff(content)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	status, err := client.GetLiveStatus()
+	if err != nil {
+		return err
+	}
+	if status.PercentageCharged == nil || status.TotalPackEnergy == nil {
+		return fmt.Errorf("live_status did not include the battery charge")
+	}
+	reserve, err := client.GetBackupReserve()
+	if err != nil {
+		return err
+	}
+	mode, err := client.GetOperationMode()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	observations, err := forecast.Fetch(client, now.AddDate(0, 0, -options.HistoryDays), now, loc)
+	if err != nil {
+		return err
+	}
+	intervals, err := forecast.New(observations, loc).Forecast(now, int(24*time.Hour/forecast.SlotDuration))
+	if err != nil {
+		return err
+	}
+
+	plan, err := optimizer.New(tariff,
+		optimizer.WithCapacity(*status.TotalPackEnergy),
+		optimizer.WithMinReserve(options.MinReserve),
+		optimizer.WithCurrentSettings(mode, reserve),
+	).Plan(*status.PercentageCharged, forecast.OptimizerForecast(intervals), loc)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tACTION\tRESERVE\tSOC\tIMPORT_WH\tEXPORT_WH\tCOST")
+	for _, step := range plan.Steps {
+		fmt.Fprintf(w, "%s\t%s\t%d%%\t%.0f%% -> %.0f%%\t%.0f\t%.0f\t%.2f\n",
+			step.Start.In(loc).Format("Mon 15:04"), step.Action, step.ReservePercent,
+			step.StartSOC, step.EndSOC, step.ImportWh, step.ExportWh, step.Cost)
+	}
+	w.Flush()
+	fmt.Printf("\nPlanned cost: %.2f (self-powered at %d%%: %.2f)\n\n", plan.Cost, options.MinReserve, plan.BaselineCost)
+
+	if len(plan.Commands) == 0 {
+		fmt.Println("No changes needed")
+		return nil
+	}
+	fmt.Println("Commands:")
+	for _, cmd := range plan.Commands {
+		fmt.Printf("  %s\n", cmd)
+	}
+	if !execute {
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err = plan.Execute(ctx, client, func(cmd optimizer.Command, err error) {
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Errorf("Failed to %s", cmd)
+			return
+		}
+		log.Infof("Applied %s", cmd)
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/blampe/powerwall"
+	"github.com/blampe/powerwall/scheduler"
+	log "github.com/sirupsen/logrus"
+)
+
+// runSchedule applies the schedule in configPath to the selected site until
+// interrupted.
+func runSchedule(client *powerwall.Client, configPath string) error {
+	config, err := scheduler.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	s, err := scheduler.NewFromConfig(client, config,
+		scheduler.WithChangeHandler(logScheduleChange),
+		scheduler.WithErrorHandler(func(err error) { logError("Schedule error", err) }),
+	)
+	if err != nil {
+		return err
+	}
+
+	loc, err := s.Location()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Fprintf(os.Stderr, "Running %d schedule entries for energy site %d in %s\n",
+		len(config.Entries), client.GetSelectedEnergySite(), loc)
+	err = s.Run(ctx)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// logScheduleChange logs every setting the scheduler applies
+func logScheduleChange(c scheduler.Change) {
+	fields := log.Fields{
+		"entry":     c.Entry,
+		"setting":   c.Setting,
+		"value":     c.Value,
+		"scheduled": c.Scheduled,
+		"reconcile": c.Reconcile,
+	}
+	if c.Err != nil {
+		log.WithFields(fields).WithField("err", c.Err).Error("Scheduled change failed")
+		return
+	}
+	log.WithFields(fields).Info("Applied scheduled change")
+}
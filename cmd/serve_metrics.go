@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/blampe/powerwall"
+	"github.com/blampe/powerwall/metrics"
+)
+
+// serveMetrics polls the given sites (or all sites on the account if none are
+// given), serving Prometheus metrics and pushing to any configured Influx/OTLP
+// endpoints until interrupted.
+func serveMetrics(client *powerwall.Client, exporter *metrics.Exporter, siteIDs []int64) error {
+	sinks := []metrics.Sink{exporter}
+	if options.InfluxURL != "" {
+		sinks = append(sinks, metrics.NewInfluxSink(options.InfluxURL, metrics.WithInfluxToken(options.InfluxToken)))
+	}
+	if options.OTLPEndpoint != "" {
+		sinks = append(sinks, metrics.NewOTLPSink(options.OTLPEndpoint))
+	}
+
+	poller := metrics.NewPoller(sinks,
+		metrics.WithSites(siteIDs...),
+		metrics.WithInterval(options.Interval),
+		metrics.WithHistoryInterval(options.History),
+		metrics.WithErrorHandler(func(err error) { logError("Polling failed", err) }),
+	)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", exporter.Handler())
+	server := &http.Server{Addr: options.ListenAddr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		err := poller.Run(ctx, client)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			logError("Metrics poller stopped", err)
+			stop()
+		}
+	}()
+
+	fmt.Fprintf(os.Stderr, "Serving metrics on %s/metrics\n", options.ListenAddr)
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
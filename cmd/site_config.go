@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/blampe/powerwall"
+)
+
+// printSiteConfig prints a site configuration for humans
+func printSiteConfig(cfg *powerwall.SiteConfig) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Site:\t%s (%s)\n", cfg.SiteName, cfg.ID)
+	if !cfg.InstallationDate.IsZero() {
+		fmt.Fprintf(w, "Installed:\t%s\n", cfg.InstallationDate.Format("2006-01-02"))
+	}
+	fmt.Fprintf(w, "Time zone:\t%s\n", cfg.InstallationTimeZone)
+	if cfg.Utility != "" {
+		fmt.Fprintf(w, "Utility:\t%s\n", cfg.Utility)
+	}
+	if cfg.TariffID != "" {
+		fmt.Fprintf(w, "Tariff:\t%s\n", cfg.TariffID)
+	}
+	fmt.Fprintf(w, "Software:\t%s\n", cfg.Version)
+	fmt.Fprintln(w)
+
+	fmt.Fprintf(w, "Batteries:\t%d", cfg.BatteryCount)
+	if cfg.Components.BatteryType != "" {
+		fmt.Fprintf(w, " (%s)", cfg.Components.BatteryType)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Nameplate power:\t%.1f kW\n", float64(cfg.NameplatePower)/1000)
+	fmt.Fprintf(w, "Operation mode:\t%s\n", cfg.DefaultRealMode)
+	if cfg.BackupReservePercent != nil {
+		fmt.Fprintf(w, "Backup reserve:\t%d%%\n", *cfg.BackupReservePercent)
+	}
+	fmt.Fprintf(w, "VPP backup reserve:\t%d%%\n", cfg.VPPBackupReservePercent)
+	fmt.Fprintf(w, "Components:\t%s\n", formatComponents(cfg.Components))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(cfg.Components.Gateways) == 0 {
+		return nil
+	}
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "GATEWAY\tSERIAL\tPART\tFIRMWARE\tACTIVE")
+	for _, g := range cfg.Components.Gateways {
+		part := g.PartName
+		if part == "" {
+			part = g.PartNumber
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\n", g.DIN, g.SerialNumber, part, g.FirmwareVersion, g.IsActive)
+	}
+	return w.Flush()
+}
+
+// formatComponents lists the installed components and supported features
+func formatComponents(c powerwall.SiteComponents) string {
+	var names []string
+	for name, present := range map[string]bool{
+		"solar":         c.Solar,
+		"battery":       c.Battery,
+		"grid":          c.Grid,
+		"backup":        c.Backup,
+		"load meter":    c.LoadMeter,
+		"time-of-use":   c.TOUCapable,
+		"storm watch":   c.StormModeCapable,
+		"grid services": c.GridServicesEnabled,
+		"configurable":  c.Configurable,
+	} {
+		if present {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/blampe/powerwall"
+)
+
+// maxWatchEvents is the number of recent events shown below the power flows.
+const maxWatchEvents = 5
+
+// watch polls the selected site and redraws a power-flow view on every new
+// snapshot until interrupted.
+func watch(client *powerwall.Client) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var prev *powerwall.LiveStatus
+	var events []powerwall.Event
+	for status := range client.Watch(ctx, options.Interval) {
+		events = append(events, powerwall.DetectEvents(prev, &status, options.SOEThresholds)...)
+		if len(events) > maxWatchEvents {
+			events = events[len(events)-maxWatchEvents:]
+		}
+		prev = &status
+
+		// Clear the screen and move the cursor home before redrawing
+		fmt.Print("\033[H\033[2J")
+		fmt.Print(renderPowerFlow(client.GetSelectedEnergySite(), &status, events))
+	}
+}
+
+func renderPowerFlow(siteID int64, status *powerwall.LiveStatus, events []powerwall.Event) string {
+	var b strings.Builder
+
+	kw := func(v *float64) string {
+		if v == nil {
+			return "     -   "
+		}
+		return fmt.Sprintf("%6.2f kW", *v/1000)
+	}
+	direction := func(v *float64, positive, negative string) string {
+		switch {
+		case v == nil || *v == 0:
+			return ""
+		case *v > 0:
+			return positive
+		default:
+			return negative
+		}
+	}
+
+	fmt.Fprintf(&b, "Energy site %d  %s\n\n", siteID, status.Timestamp.Local().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&b, "  Solar    %s\n", kw(status.SolarPower))
+	fmt.Fprintf(&b, "  Battery  %s  %s\n", kw(status.BatteryPower), direction(status.BatteryPower, "discharging", "charging"))
+	fmt.Fprintf(&b, "  Grid     %s  %s\n", kw(status.GridPower), direction(status.GridPower, "importing", "exporting"))
+	fmt.Fprintf(&b, "  Home     %s\n\n", kw(status.LoadPower))
+
+	if flows, err := powerwall.NewFlowBreakdown(status); err == nil {
+		for _, flow := range []struct {
+			name  string
+			value float64
+		}{
+			{"Solar → Home", flows.SolarToHome},
+			{"Solar → Battery", flows.SolarToBattery},
+			{"Solar → Grid", flows.SolarToGrid},
+			{"Battery → Home", flows.BatteryToHome},
+			{"Battery → Grid", flows.BatteryToGrid},
+			{"Grid → Home", flows.GridToHome},
+			{"Grid → Battery", flows.GridToBattery},
+		} {
+			if flow.value > 0 {
+				fmt.Fprintf(&b, "  %-16s %s\n", flow.name, kw(&flow.value))
+			}
+		}
+		if !flows.Balanced() {
+			fmt.Fprintf(&b, "  WARNING: flows unbalanced by %.2f kW, check the meters\n", flows.Imbalance/1000)
+		}
+		fmt.Fprintln(&b)
+	}
+
+	if status.PercentageCharged != nil {
+		fmt.Fprintf(&b, "  Battery charge  %.1f%%\n", *status.PercentageCharged)
+	}
+	fmt.Fprintf(&b, "  Grid status     %s\n", status.GridStatus)
+	if status.IslandStatus != "" {
+		fmt.Fprintf(&b, "  Island status   %s\n", status.IslandStatus)
+	}
+	stormWatch := "off"
+	if status.StormModeActive {
+		stormWatch = "ACTIVE"
+	}
+	fmt.Fprintf(&b, "  Storm Watch     %s\n", stormWatch)
+
+	if len(events) > 0 {
+		fmt.Fprintf(&b, "\nRecent events:\n")
+		for _, event := range events {
+			fmt.Fprintf(&b, "  %s  %s\n", event.EventTime().Local().Format(time.TimeOnly), event)
+		}
+	}
+	return b.String()
+}
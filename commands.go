@@ -0,0 +1,114 @@
+// Control command delivery and verification
+//
+//	WithCommandVerification(timeout) - Confirm commands by reading the setting back
+//
+// See audit.go for auditing and dry-run of commands.
+
+package powerwall
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// commandVerifyInterval is the time between reads while verifying a command.
+const commandVerifyInterval = 5 * time.Second
+
+// WithCommandVerification makes the Set* control commands read the setting
+// back from site_info after Tesla accepts the command, until the new value is
+// observed.  If it isn't
+// observed within timeout, the command returns a CommandNotAppliedError.
+// Verification is disabled by default.
+func WithCommandVerification(timeout time.Duration) func(c *Client) {
+	return func(c *Client) {
+		c.commandVerifyTimeout = timeout
+	}
+}
+
+// command is a control command which changes a single setting.
+type command struct {
+	setting  string // site_info field the command changes
+	value    string // Expected value of the setting afterwards
+	endpoint string
+	payload  interface{}
+
+	// read returns the current value of the setting, for the audit log and
+	// for verification.
+	read func() (string, error)
+}
+
+// runCommand posts a control command, reading the previous value of the
+// setting first if commands are audited, and verifies the change if enabled.
+// A rejection reported in the response body is returned as a
+// CommandRejectedError by doFleetRequest.
+func (c *Client) runCommand(ctx context.Context, cmd command) error {
+	var previous string
+	if c.auditSink != nil {
+		value, err := cmd.read()
+		if err != nil {
+			c.logf("Reading previous %s for audit log: %s", cmd.setting, err)
+		} else {
+			previous = value
+		}
+	}
+
+	var response CommandResponse
+	err := c.apiPostJson(ctx, cmd.endpoint, cmd.payload, &response, previous)
+	if err != nil {
+		return err
+	}
+	if c.dryRun {
+		return nil
+	}
+	c.invalidateSite(c.selectedSiteID)
+
+	c.logf("Command accepted: code=%d message=%s", response.Response.Code, response.Response.Message)
+	return c.verifyCommand(ctx, cmd.setting, cmd.value, cmd.read)
+}
+
+// verifyCommand polls observe until it returns expected, if command
+// verification is enabled.  Errors from observe are logged and polling
+// carries on, since a site which just applied a change can briefly fail to
+// respond.  Verification stops early if ctx is cancelled.
+func (c *Client) verifyCommand(ctx context.Context, setting, expected string, observe func() (string, error)) error {
+	if c.commandVerifyTimeout <= 0 {
+		return nil
+	}
+
+	deadline := time.Now().Add(c.commandVerifyTimeout)
+	var observed string
+	for {
+		// Read the setting from the API, not from the cache
+		c.invalidateSite(c.selectedSiteID)
+		value, err := observe()
+		if err != nil {
+			c.logf("Verifying %s: %s", setting, err)
+		} else {
+			observed = value
+			if observed == expected {
+				c.logf("Verified %s is %s", setting, expected)
+				return nil
+			}
+		}
+
+		if time.Now().Add(commandVerifyInterval).After(deadline) {
+			break
+		}
+		select {
+		case <-time.After(commandVerifyInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	err := CommandNotAppliedError{
+		EnergyProductID: c.selectedSiteID,
+		Setting:         setting,
+		Expected:        expected,
+		Observed:        observed,
+		Timeout:         c.commandVerifyTimeout,
+	}
+	c.logError(fmt.Sprintf("%s change was accepted but not applied", setting), err)
+	return err
+}
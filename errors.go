@@ -1,22 +1,68 @@
 package powerwall
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
 // ApiError indicates that something unexpected occurred with the HTTP API
 // call.  This usually occurs when the endpoint returns an unexpected status
 // code.
+//
+// Fleet API error bodies are JSON; when the body could be parsed, ErrorCode,
+// Description and TxID hold its error, error_description and txid fields.
+// Quote the TxID when contacting Tesla support about a failed request.
 type ApiError struct {
 	URL        url.URL
 	StatusCode int
 	Body       []byte
+
+	ErrorCode   string
+	Description string
+	TxID        string
+
+	// EnergyProductID is the energy site the request was for, or zero if
+	// the endpoint isn't specific to a site.
+	EnergyProductID int64
 }
 
 func (e ApiError) Error() string {
-	return fmt.Sprintf("API call to %s returned unexpected status code %d (%#v)", e.URL.String(), e.StatusCode, string(e.Body))
+	if e.ErrorCode == "" && e.Description == "" {
+		return fmt.Sprintf("API call to %s returned unexpected status code %d (%#v)", e.URL.String(), e.StatusCode, string(e.Body))
+	}
+
+	msg := fmt.Sprintf("API call to %s returned status code %d: %s", e.URL.String(), e.StatusCode, e.ErrorCode)
+	if e.Description != "" && e.Description != e.ErrorCode {
+		msg += " (" + e.Description + ")"
+	}
+	if e.TxID != "" {
+		msg += " [txid " + e.TxID + "]"
+	}
+	return msg
+}
+
+// Unwrap returns an EnergyProductError for errors from site-specific
+// endpoints, so they can be matched with errors.As.
+func (e ApiError) Unwrap() error {
+	if e.EnergyProductID == 0 {
+		return nil
+	}
+	message := e.Description
+	if message == "" {
+		message = string(e.Body)
+	}
+	return EnergyProductError{
+		EnergyProductID: e.EnergyProductID,
+		ErrorType:       e.ErrorCode,
+		Message:         message,
+	}
 }
 
 // AuthFailure is returned when the client was unable to perform a request
@@ -77,3 +123,238 @@ type EnergyProductError struct {
 func (e EnergyProductError) Error() string {
 	return fmt.Sprintf("Energy site %d error (%s): %s", e.EnergyProductID, e.ErrorType, e.Message)
 }
+
+///////////////////////////////////////////////////////////////////////////////
+// Typed Fleet API errors
+
+// Sentinel errors matched by the typed Fleet API errors below, for use with
+// errors.Is.
+var (
+	ErrNotFound        = errors.New("not found")
+	ErrSiteOffline     = errors.New("energy site offline")
+	ErrForbidden       = errors.New("forbidden")
+	ErrCommandRejected = errors.New("command rejected")
+	ErrUpstreamTimeout = errors.New("upstream timeout")
+
+	ErrCommandNotApplied = errors.New("command not applied")
+	ErrStaleData         = errors.New("stale data")
+)
+
+// NotFoundError indicates that the requested resource, usually an energy
+// site, doesn't exist or isn't visible to this account.
+type NotFoundError struct {
+	ApiError
+}
+
+func (e NotFoundError) Error() string {
+	return ErrNotFound.Error() + ": " + e.ApiError.Error()
+}
+
+func (e NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+func (e NotFoundError) Unwrap() error {
+	return e.ApiError
+}
+
+// SiteOfflineError indicates that the gateway isn't connected to Tesla, so
+// the request couldn't be delivered to it.
+type SiteOfflineError struct {
+	ApiError
+}
+
+func (e SiteOfflineError) Error() string {
+	return ErrSiteOffline.Error() + ": " + e.ApiError.Error()
+}
+
+func (e SiteOfflineError) Is(target error) bool {
+	return target == ErrSiteOffline
+}
+
+func (e SiteOfflineError) Unwrap() error {
+	return e.ApiError
+}
+
+// StaleDataError indicates that live_status data is older than the client's
+// stale threshold (see WithStaleThreshold), which happens when the gateway
+// has lost its connection to Tesla and the Fleet API serves the last data it
+// received.  It matches ErrSiteOffline as well as ErrStaleData.  Status holds
+// the stale data.
+type StaleDataError struct {
+	EnergyProductID int64
+	Timestamp       time.Time // When the gateway reported the data
+	ServerTime      time.Time // The Fleet API's time when the data was received
+	Age             time.Duration
+	Threshold       time.Duration
+	Status          *LiveStatus
+}
+
+func (e StaleDataError) Error() string {
+	return fmt.Sprintf("%s: energy site %d last reported %s ago at %s (threshold %s)",
+		ErrStaleData, e.EnergyProductID, e.Age.Round(time.Second), e.Timestamp.Format(time.RFC3339), e.Threshold)
+}
+
+func (e StaleDataError) Is(target error) bool {
+	return target == ErrStaleData || target == ErrSiteOffline
+}
+
+// ForbiddenError indicates that the token isn't allowed to make the request,
+// most often because the Fleet API application wasn't granted the required
+// scope.
+type ForbiddenError struct {
+	ApiError
+}
+
+func (e ForbiddenError) Error() string {
+	return ErrForbidden.Error() + ": " + e.ApiError.Error()
+}
+
+func (e ForbiddenError) Is(target error) bool {
+	return target == ErrForbidden
+}
+
+func (e ForbiddenError) Unwrap() error {
+	return e.ApiError
+}
+
+// ScopeMissing returns true if the request was refused because the token
+// lacks an OAuth scope, such as energy_cmds for control commands.
+func (e ForbiddenError) ScopeMissing() bool {
+	return strings.Contains(strings.ToLower(e.ErrorCode+" "+e.Description), "scope")
+}
+
+// CommandRejectedError indicates that a control command was refused, either
+// with an error status or with a result of false in a successful response.
+type CommandRejectedError struct {
+	ApiError
+}
+
+func (e CommandRejectedError) Error() string {
+	return ErrCommandRejected.Error() + ": " + e.ApiError.Error()
+}
+
+func (e CommandRejectedError) Is(target error) bool {
+	return target == ErrCommandRejected
+}
+
+func (e CommandRejectedError) Unwrap() error {
+	return e.ApiError
+}
+
+// UpstreamTimeoutError indicates that the Fleet API timed out waiting for
+// Tesla's backend or the gateway.  The request may be retried.
+type UpstreamTimeoutError struct {
+	ApiError
+}
+
+func (e UpstreamTimeoutError) Error() string {
+	return ErrUpstreamTimeout.Error() + ": " + e.ApiError.Error()
+}
+
+func (e UpstreamTimeoutError) Is(target error) bool {
+	return target == ErrUpstreamTimeout
+}
+
+func (e UpstreamTimeoutError) Unwrap() error {
+	return e.ApiError
+}
+
+// energySitePath matches the site ID in site-specific endpoint paths
+var energySitePath = regexp.MustCompile(`^/api/1/energy_sites/(\d+)/`)
+
+// newApiError parses a Fleet API error response into the most specific error
+// type that fits, falling back to a plain ApiError.
+func newApiError(method string, u url.URL, statusCode int, body []byte) error {
+	apiErr := ApiError{URL: u, StatusCode: statusCode, Body: body}
+
+	var fields struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+		TxID             string `json:"txid"`
+	}
+	if json.Unmarshal(body, &fields) == nil {
+		apiErr.ErrorCode = fields.Error
+		apiErr.Description = fields.ErrorDescription
+		apiErr.TxID = fields.TxID
+	}
+	if m := energySitePath.FindStringSubmatch(u.Path); m != nil {
+		apiErr.EnergyProductID, _ = strconv.ParseInt(m[1], 10, 64)
+	}
+
+	text := strings.ToLower(apiErr.ErrorCode + " " + apiErr.Description)
+	switch {
+	case statusCode == http.StatusRequestTimeout || strings.Contains(text, "offline"):
+		return SiteOfflineError{apiErr}
+	case statusCode == http.StatusNotFound:
+		return NotFoundError{apiErr}
+	case statusCode == http.StatusForbidden:
+		return ForbiddenError{apiErr}
+	case statusCode == http.StatusGatewayTimeout || strings.Contains(text, "timeout") || strings.Contains(text, "timed out"):
+		return UpstreamTimeoutError{apiErr}
+	case method == http.MethodPost && (statusCode == http.StatusBadRequest || statusCode == http.StatusUnprocessableEntity):
+		return CommandRejectedError{apiErr}
+	}
+	return apiErr
+}
+
+// commandResult returns a CommandRejectedError if a successful command
+// response nevertheless reports a result of false or a non-2xx code.
+func commandResult(u url.URL, body []byte) error {
+	var response struct {
+		Response struct {
+			Result  *bool  `json:"result"`
+			Reason  string `json:"reason"`
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"response"`
+	}
+	if json.Unmarshal(body, &response) != nil {
+		return nil
+	}
+
+	r := response.Response
+	rejected := r.Result != nil && !*r.Result
+	if r.Code != 0 && (r.Code < 200 || r.Code >= 300) {
+		rejected = true
+	}
+	if !rejected {
+		return nil
+	}
+
+	reason := r.Reason
+	if reason == "" {
+		reason = r.Message
+	}
+	apiErr := ApiError{
+		URL:         u,
+		StatusCode:  http.StatusOK,
+		Body:        body,
+		ErrorCode:   "command_failed",
+		Description: reason,
+	}
+	if m := energySitePath.FindStringSubmatch(u.Path); m != nil {
+		apiErr.EnergyProductID, _ = strconv.ParseInt(m[1], 10, 64)
+	}
+	return CommandRejectedError{apiErr}
+}
+
+// CommandNotAppliedError is returned by control commands when command
+// verification is enabled (see WithCommandVerification) and the new value
+// wasn't observed before the timeout, although Tesla accepted the command.
+type CommandNotAppliedError struct {
+	EnergyProductID int64
+	Setting         string
+	Expected        string
+	Observed        string // Last value read, empty if it couldn't be read
+	Timeout         time.Duration
+}
+
+func (e CommandNotAppliedError) Error() string {
+	return fmt.Sprintf("command not applied: energy site %d %s is %q after %s, expected %q",
+		e.EnergyProductID, e.Setting, e.Observed, e.Timeout, e.Expected)
+}
+
+func (e CommandNotAppliedError) Is(target error) bool {
+	return target == ErrCommandNotApplied
+}
@@ -0,0 +1,126 @@
+// Package export writes Powerwall history as tabular data.
+//
+// History time series are flattened to one row per timestamp, with a fixed
+// set of columns in a stable order.  Column names carry their unit as a
+// suffix ("_w" for watts, "_wh" for watt-hours) and values the API didn't
+// report are written as empty cells (or nulls).
+//
+//	NewWriter(format, w) - Creates a CSV/JSONL/table/Parquet writer
+//	Flatten(points)      - Sorts points and merges equal timestamps
+//	Range(client, w, kind, period, start, end, progress) - Streams a long date range
+package export
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/blampe/powerwall"
+)
+
+// Supported output formats.
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatTable   = "table"
+	FormatParquet = "parquet"
+)
+
+// Formats lists the supported output formats.
+var Formats = []string{FormatCSV, FormatJSONL, FormatTable, FormatParquet}
+
+// Column describes one exported value of a TimePoint.
+type Column struct {
+	Name  string // Column name including unit suffix, e.g. "solar_power_w"
+	Unit  string // "W" or "Wh"
+	value func(p *powerwall.TimePoint) *float64
+}
+
+// TimestampColumn is the name of the first column of every row.
+const TimestampColumn = "timestamp"
+
+// Columns lists the value columns in output order.  The timestamp column
+// always comes first and is not included here.
+var Columns = []Column{
+	{"solar_power_w", "W", func(p *powerwall.TimePoint) *float64 { return p.SolarPower }},
+	{"battery_power_w", "W", func(p *powerwall.TimePoint) *float64 { return p.BatteryPower }},
+	{"grid_power_w", "W", func(p *powerwall.TimePoint) *float64 { return p.GridPower }},
+	{"grid_services_power_w", "W", func(p *powerwall.TimePoint) *float64 { return p.GridServicesPower }},
+	{"generator_power_w", "W", func(p *powerwall.TimePoint) *float64 { return p.GeneratorPower }},
+	{"solar_energy_exported_wh", "Wh", func(p *powerwall.TimePoint) *float64 { return p.SolarEnergyExported }},
+	{"grid_energy_imported_wh", "Wh", func(p *powerwall.TimePoint) *float64 { return p.GridEnergyImported }},
+	{"grid_energy_exported_wh", "Wh", func(p *powerwall.TimePoint) *float64 { return p.GridEnergyExported }},
+	{"battery_energy_exported_wh", "Wh", func(p *powerwall.TimePoint) *float64 { return p.BatteryEnergyExported }},
+	{"battery_energy_imported_wh", "Wh", func(p *powerwall.TimePoint) *float64 { return p.BatteryEnergyImported }},
+	{"consumer_energy_imported_wh", "Wh", func(p *powerwall.TimePoint) *float64 { return p.ConsumerEnergyImported }},
+}
+
+// Value returns the column's value in p, or nil if it wasn't reported.
+func (c Column) Value(p *powerwall.TimePoint) *float64 {
+	return c.value(p)
+}
+
+// Writer writes rows of history data.  Write may be called repeatedly to
+// stream a long range; Close must be called to flush buffered output (it
+// does not close the underlying io.Writer).
+type Writer interface {
+	Write(points []powerwall.TimePoint) error
+	Close() error
+}
+
+// NewWriter creates a Writer for the given format.
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w), nil
+	case FormatJSONL:
+		return newJSONLWriter(w), nil
+	case FormatTable:
+		return newTableWriter(w), nil
+	case FormatParquet:
+		return newParquetWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unsupported export format: %s (supported: csv, jsonl, table, parquet)", format)
+	}
+}
+
+// Flatten returns the points sorted by timestamp, with points that share a
+// timestamp merged into a single row.  When more than one point reports the
+// same value, the later one wins.
+func Flatten(points []powerwall.TimePoint) []powerwall.TimePoint {
+	sorted := make([]powerwall.TimePoint, len(points))
+	copy(sorted, points)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+
+	var result []powerwall.TimePoint
+	for _, p := range sorted {
+		if n := len(result); n > 0 && result[n-1].Timestamp.Equal(p.Timestamp) {
+			merge(&result[n-1], &p)
+			continue
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// merge copies the values reported in src into dst.
+func merge(dst, src *powerwall.TimePoint) {
+	set := func(d **float64, s *float64) {
+		if s != nil {
+			*d = s
+		}
+	}
+	set(&dst.SolarPower, src.SolarPower)
+	set(&dst.BatteryPower, src.BatteryPower)
+	set(&dst.GridPower, src.GridPower)
+	set(&dst.GridServicesPower, src.GridServicesPower)
+	set(&dst.GeneratorPower, src.GeneratorPower)
+	set(&dst.SolarEnergyExported, src.SolarEnergyExported)
+	set(&dst.GridEnergyImported, src.GridEnergyImported)
+	set(&dst.GridEnergyExported, src.GridEnergyExported)
+	set(&dst.BatteryEnergyExported, src.BatteryEnergyExported)
+	set(&dst.BatteryEnergyImported, src.BatteryEnergyImported)
+	set(&dst.ConsumerEnergyImported, src.ConsumerEnergyImported)
+}
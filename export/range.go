@@ -0,0 +1,66 @@
+package export
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/blampe/powerwall"
+)
+
+// Range streams calendar_history of the given kind ("energy", "backup" or
+// "power") for [start, end) to w.  The range is requested one period at a
+// time, so arbitrarily long ranges can be exported at the period's full
+// resolution without holding them in memory.  The progress callback, if not
+// nil, is called after each chunk with the start of the next one.
+func Range(client *powerwall.Client, w Writer, kind, period string, start, end time.Time, progress func(time.Time)) error {
+	step, err := periodStep(period)
+	if err != nil {
+		return err
+	}
+
+	for chunkStart := start; chunkStart.Before(end); {
+		chunkEnd := step(chunkStart)
+		if chunkEnd.After(end) {
+			chunkEnd = end
+		}
+
+		history, err := client.GetCalendarHistory(kind,
+			chunkStart.Format(time.RFC3339), chunkEnd.Format(time.RFC3339), period)
+		if err != nil {
+			return fmt.Errorf("fetching %s history from %s: %w", kind, chunkStart.Format(time.RFC3339), err)
+		}
+
+		// The API returns whole periods, which can reach past the range
+		var points []powerwall.TimePoint
+		for _, p := range history.TimeSeries {
+			if !p.Timestamp.Before(start) && p.Timestamp.Before(end) {
+				points = append(points, p)
+			}
+		}
+		if err := w.Write(points); err != nil {
+			return err
+		}
+
+		chunkStart = chunkEnd
+		if progress != nil {
+			progress(chunkStart)
+		}
+	}
+	return nil
+}
+
+// periodStep returns a function which advances a time by one period.
+func periodStep(period string) (func(time.Time) time.Time, error) {
+	switch period {
+	case "day":
+		return func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }, nil
+	case "week":
+		return func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }, nil
+	case "month":
+		return func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }, nil
+	case "year":
+		return func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }, nil
+	default:
+		return nil, fmt.Errorf("invalid period for export: %s (supported: day, week, month, year)", period)
+	}
+}
@@ -0,0 +1,233 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/blampe/powerwall"
+	"github.com/parquet-go/parquet-go"
+)
+
+// rowWriter is implemented by each output format.  Rows arrive flattened and
+// in strictly increasing timestamp order.
+type rowWriter interface {
+	writeRows(points []powerwall.TimePoint) error
+	Close() error
+}
+
+// streamWriter flattens each batch and drops rows at or before the last
+// written timestamp, so that overlapping batches (e.g. from adjacent date
+// ranges which share a boundary) still produce one row per timestamp.
+type streamWriter struct {
+	rows rowWriter
+	last time.Time
+}
+
+func (w *streamWriter) Write(points []powerwall.TimePoint) error {
+	var fresh []powerwall.TimePoint
+	for _, p := range Flatten(points) {
+		if w.last.IsZero() || p.Timestamp.After(w.last) {
+			fresh = append(fresh, p)
+		}
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+	w.last = fresh[len(fresh)-1].Timestamp
+	return w.rows.writeRows(fresh)
+}
+
+func (w *streamWriter) Close() error {
+	return w.rows.Close()
+}
+
+func formatValue(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// CSV
+
+type csvWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func newCSVWriter(w io.Writer) Writer {
+	return &streamWriter{rows: &csvWriter{w: csv.NewWriter(w)}}
+}
+
+func (c *csvWriter) writeRows(points []powerwall.TimePoint) error {
+	if !c.headerWritten {
+		header := []string{TimestampColumn}
+		for _, col := range Columns {
+			header = append(header, col.Name)
+		}
+		if err := c.w.Write(header); err != nil {
+			return err
+		}
+		c.headerWritten = true
+	}
+
+	record := make([]string, len(Columns)+1)
+	for i := range points {
+		record[0] = points[i].Timestamp.Format(time.RFC3339)
+		for j, col := range Columns {
+			record[j+1] = formatValue(col.Value(&points[i]))
+		}
+		if err := c.w.Write(record); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// JSON Lines
+
+type jsonlWriter struct {
+	w io.Writer
+}
+
+func newJSONLWriter(w io.Writer) Writer {
+	return &streamWriter{rows: &jsonlWriter{w: w}}
+}
+
+// writeRows writes one object per row.  The objects are built by hand so the
+// keys come out in column order, with null for values that weren't reported.
+func (j *jsonlWriter) writeRows(points []powerwall.TimePoint) error {
+	var buf bytes.Buffer
+	for i := range points {
+		ts, err := json.Marshal(points[i].Timestamp)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(`{"` + TimestampColumn + `":`)
+		buf.Write(ts)
+		for _, col := range Columns {
+			buf.WriteString(`,"` + col.Name + `":`)
+			if v := col.Value(&points[i]); v != nil {
+				buf.WriteString(formatValue(v))
+			} else {
+				buf.WriteString("null")
+			}
+		}
+		buf.WriteString("}\n")
+	}
+	_, err := j.w.Write(buf.Bytes())
+	return err
+}
+
+func (j *jsonlWriter) Close() error {
+	return nil
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Table
+
+type tableWriter struct {
+	w             *tabwriter.Writer
+	headerWritten bool
+}
+
+func newTableWriter(w io.Writer) Writer {
+	return &streamWriter{rows: &tableWriter{w: tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)}}
+}
+
+// writeRows buffers rows in the tabwriter, since column widths can't be known
+// until all rows have been seen.  Output is produced on Close.
+func (t *tableWriter) writeRows(points []powerwall.TimePoint) error {
+	if !t.headerWritten {
+		header := []string{strings.ToUpper(TimestampColumn)}
+		for _, col := range Columns {
+			header = append(header, strings.ToUpper(col.Name))
+		}
+		if _, err := io.WriteString(t.w, strings.Join(header, "\t")+"\t\n"); err != nil {
+			return err
+		}
+		t.headerWritten = true
+	}
+
+	for i := range points {
+		fields := []string{points[i].Timestamp.Format("2006-01-02 15:04:05 -0700")}
+		for _, col := range Columns {
+			fields = append(fields, formatValue(col.Value(&points[i])))
+		}
+		if _, err := io.WriteString(t.w, strings.Join(fields, "\t")+"\t\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *tableWriter) Close() error {
+	return t.w.Flush()
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Parquet
+
+// parquetRow mirrors Columns.  Keep the two in the same order.
+type parquetRow struct {
+	Timestamp                time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	SolarPowerW              *float64  `parquet:"solar_power_w,optional"`
+	BatteryPowerW            *float64  `parquet:"battery_power_w,optional"`
+	GridPowerW               *float64  `parquet:"grid_power_w,optional"`
+	GridServicesPowerW       *float64  `parquet:"grid_services_power_w,optional"`
+	GeneratorPowerW          *float64  `parquet:"generator_power_w,optional"`
+	SolarEnergyExportedWh    *float64  `parquet:"solar_energy_exported_wh,optional"`
+	GridEnergyImportedWh     *float64  `parquet:"grid_energy_imported_wh,optional"`
+	GridEnergyExportedWh     *float64  `parquet:"grid_energy_exported_wh,optional"`
+	BatteryEnergyExportedWh  *float64  `parquet:"battery_energy_exported_wh,optional"`
+	BatteryEnergyImportedWh  *float64  `parquet:"battery_energy_imported_wh,optional"`
+	ConsumerEnergyImportedWh *float64  `parquet:"consumer_energy_imported_wh,optional"`
+}
+
+type parquetWriter struct {
+	w *parquet.GenericWriter[parquetRow]
+}
+
+func newParquetWriter(w io.Writer) Writer {
+	return &streamWriter{rows: &parquetWriter{w: parquet.NewGenericWriter[parquetRow](w)}}
+}
+
+func (p *parquetWriter) writeRows(points []powerwall.TimePoint) error {
+	rows := make([]parquetRow, len(points))
+	for i, point := range points {
+		rows[i] = parquetRow{
+			Timestamp:                point.Timestamp,
+			SolarPowerW:              point.SolarPower,
+			BatteryPowerW:            point.BatteryPower,
+			GridPowerW:               point.GridPower,
+			GridServicesPowerW:       point.GridServicesPower,
+			GeneratorPowerW:          point.GeneratorPower,
+			SolarEnergyExportedWh:    point.SolarEnergyExported,
+			GridEnergyImportedWh:     point.GridEnergyImported,
+			GridEnergyExportedWh:     point.GridEnergyExported,
+			BatteryEnergyExportedWh:  point.BatteryEnergyExported,
+			BatteryEnergyImportedWh:  point.BatteryEnergyImported,
+			ConsumerEnergyImportedWh: point.ConsumerEnergyImported,
+		}
+	}
+	_, err := p.w.Write(rows)
+	return err
+}
+
+func (p *parquetWriter) Close() error {
+	return p.w.Close()
+}
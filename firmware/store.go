@@ -0,0 +1,125 @@
+package firmware
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/blampe/powerwall/internal/jsonfile"
+	"github.com/blampe/powerwall/metrics"
+)
+
+// SiteSoftware is the Component name used for the site's software version,
+// as opposed to the firmware of one of its gateways.
+const SiteSoftware = "site"
+
+// Record is a version seen on one component of an energy site, and the period
+// over which it was seen.
+type Record struct {
+	// Component is SiteSoftware or the DIN of a gateway.
+	Component string    `json:"component"`
+	Version   string    `json:"version"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Store keeps the history of versions seen at each energy site, optionally
+// persisted to a JSON file.
+type Store struct {
+	path string
+
+	mu    sync.Mutex
+	sites map[int64]*siteHistory
+}
+
+// siteHistory is the persisted history of one energy site.
+type siteHistory struct {
+	Name    string   `json:"name"`
+	Records []Record `json:"records"`
+}
+
+// OpenStore opens the store at path, creating it on the first write if it
+// doesn't exist.  An empty path gives a store which is only kept in memory.
+func OpenStore(path string) (*Store, error) {
+	s := &Store{path: path, sites: map[int64]*siteHistory{}}
+	if path == "" {
+		return s, nil
+	}
+
+	if err := jsonfile.Read(path, &s.sites); err != nil {
+		return nil, fmt.Errorf("reading firmware store %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// History returns every version seen at an energy site, oldest first.
+func (s *Store) History(siteID int64) []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.sites[siteID]
+	if !ok {
+		return nil
+	}
+	records := make([]Record, len(h.Records))
+	copy(records, h.Records)
+	return records
+}
+
+// Observe records the versions currently running at a site and returns an
+// Update for each component whose version differs from the last one seen.
+// The first version seen for a component is recorded without an Update.
+func (s *Store) Observe(site metrics.Site, versions map[string]string, now time.Time) ([]Update, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.sites[site.ID]
+	if !ok {
+		h = &siteHistory{}
+		s.sites[site.ID] = h
+	}
+	h.Name = site.Name
+
+	var updates []Update
+	for _, component := range sortedKeys(versions) {
+		version := versions[component]
+		if version == "" {
+			continue
+		}
+
+		last := h.latest(component)
+		if last != nil && last.Version == version {
+			last.LastSeen = now
+			continue
+		}
+
+		h.Records = append(h.Records, Record{Component: component, Version: version, FirstSeen: now, LastSeen: now})
+		if last != nil {
+			updates = append(updates, Update{
+				Site:      site,
+				Component: component,
+				From:      last.Version,
+				To:        version,
+				Time:      now,
+			})
+		}
+	}
+
+	if s.path != "" {
+		if err := jsonfile.Write(s.path, s.sites); err != nil {
+			return updates, fmt.Errorf("saving firmware store: %w", err)
+		}
+	}
+	return updates, nil
+}
+
+// latest returns the most recent record for a component, or nil if it has
+// never been seen.
+func (h *siteHistory) latest(component string) *Record {
+	for i := len(h.Records) - 1; i >= 0; i-- {
+		if h.Records[i].Component == component {
+			return &h.Records[i]
+		}
+	}
+	return nil
+}
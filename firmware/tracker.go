@@ -0,0 +1,197 @@
+// Package firmware tracks the software versions running at energy sites.
+//
+// A Tracker periodically reads the site software version and the firmware
+// version of every gateway from site_info, records each version in a Store
+// along with when it was first and last seen, and reports an Update whenever
+// a version changes.  This makes it possible to correlate changes in
+// behaviour with firmware pushes across a fleet of sites:
+//
+//	store, _ := firmware.OpenStore("firmware.json")
+//	tracker := firmware.NewTracker(client, store,
+//		firmware.WithUpdateHandler(func(u firmware.Update) { log.Println(u) }))
+//	go tracker.Run(ctx)
+//
+//	history := store.History(siteID)
+package firmware
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/blampe/powerwall"
+	"github.com/blampe/powerwall/metrics"
+)
+
+// DefaultInterval is the default time between version checks.  Firmware
+// changes rarely, so there's no need to check often.
+const DefaultInterval = time.Hour
+
+// Update is a version change on one component of an energy site.
+type Update struct {
+	Site      metrics.Site
+	Component string // SiteSoftware or a gateway DIN
+	From      string
+	To        string
+	Time      time.Time
+}
+
+func (u Update) String() string {
+	if u.Component == SiteSoftware {
+		return fmt.Sprintf("%s: software updated %s -> %s", u.Site.Name, u.From, u.To)
+	}
+	return fmt.Sprintf("%s: gateway %s firmware updated %s -> %s", u.Site.Name, u.Component, u.From, u.To)
+}
+
+// Tracker checks the versions running at energy sites and records them in a
+// Store.
+type Tracker struct {
+	client        *powerwall.Client
+	store         *Store
+	siteIDs       []int64
+	interval      time.Duration
+	updateHandler func(Update)
+	errHandler    func(error)
+}
+
+// NewTracker creates a Tracker which records versions in store.
+func NewTracker(client *powerwall.Client, store *Store, options ...func(t *Tracker)) *Tracker {
+	t := &Tracker{
+		client:        client,
+		store:         store,
+		interval:      DefaultInterval,
+		updateHandler: func(Update) {},
+		errHandler:    func(error) {},
+	}
+
+	// Apply options
+	for _, option := range options {
+		if option != nil {
+			option(t)
+		}
+	}
+
+	return t
+}
+
+// WithSites restricts the tracker to the given energy site IDs.  By default
+// all energy sites on the account are tracked.
+func WithSites(siteIDs ...int64) func(t *Tracker) {
+	return func(t *Tracker) {
+		t.siteIDs = siteIDs
+	}
+}
+
+// WithInterval sets the time between version checks
+func WithInterval(interval time.Duration) func(t *Tracker) {
+	return func(t *Tracker) {
+		t.interval = interval
+	}
+}
+
+// WithUpdateHandler registers a callback which is called for every version
+// change detected by Run.
+func WithUpdateHandler(f func(Update)) func(t *Tracker) {
+	return func(t *Tracker) {
+		t.updateHandler = f
+	}
+}
+
+// WithErrorHandler registers a callback for sites whose versions couldn't be
+// read or saved, wrapped with the site ID.  Updates found before a save fails
+// are still passed to the update handler.
+func WithErrorHandler(f func(error)) func(t *Tracker) {
+	return func(t *Tracker) {
+		t.errHandler = f
+	}
+}
+
+// Run checks every site until ctx is cancelled, passing updates to the update
+// handler.  The tracker selects each site in turn, so the client must not be
+// used by anything else while Run is active.
+func (t *Tracker) Run(ctx context.Context) error {
+	sites, err := metrics.ResolveSitesContext(ctx, t.client, t.siteIDs)
+	if err != nil {
+		return err
+	}
+
+	interval := metrics.PollInterval(t.client, t.interval, len(sites))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, site := range sites {
+			updates, err := t.checkSite(ctx, site)
+			if err != nil {
+				t.errHandler(fmt.Errorf("site %d: %w", site.ID, err))
+			}
+			for _, u := range updates {
+				t.updateHandler(u)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check reads the versions of every site once, records them and returns the
+// updates since the previous check.
+func (t *Tracker) Check() ([]Update, error) {
+	sites, err := metrics.ResolveSites(t.client, t.siteIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []Update
+	for _, site := range sites {
+		siteUpdates, err := t.checkSite(context.Background(), site)
+		updates = append(updates, siteUpdates...)
+		if err != nil {
+			return updates, fmt.Errorf("site %d: %w", site.ID, err)
+		}
+	}
+	return updates, nil
+}
+
+// checkSite reads and records the versions of a single site.
+func (t *Tracker) checkSite(ctx context.Context, site metrics.Site) ([]Update, error) {
+	if err := t.client.SelectEnergySite(site.ID); err != nil {
+		return nil, err
+	}
+
+	versions, err := t.client.GetFirmwareVersionsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.store.Observe(site, Components(versions), time.Now())
+}
+
+// Components flattens firmware versions into a map from component name
+// (SiteSoftware or a gateway DIN) to version, as recorded by the Store.
+// Gateways without a DIN are named by their serial number.
+func Components(versions *powerwall.FirmwareVersions) map[string]string {
+	components := map[string]string{SiteSoftware: versions.Version}
+	for _, g := range versions.Gateways {
+		name := g.DIN
+		if name == "" {
+			name = g.SerialNumber
+		}
+		components[name] = g.FirmwareVersion
+	}
+	return components
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
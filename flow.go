@@ -0,0 +1,111 @@
+// Power-flow decomposition of live_status
+//
+//	NewFlowBreakdown(status) - Source-to-destination flows from one snapshot
+
+package powerwall
+
+import (
+	"fmt"
+	"math"
+)
+
+// FlowBalanceTolerance (in watts) plus FlowBalanceToleranceFraction of the
+// load is the largest imbalance FlowBreakdown.Balanced accepts.
+const (
+	FlowBalanceTolerance         = 50.0
+	FlowBalanceToleranceFraction = 0.05
+)
+
+// FlowBreakdown splits a live_status snapshot into the power flowing from
+// each source to each destination, in watts.  Every flow is zero or
+// positive.
+//
+// live_status reports one net figure per device: solar_power is production,
+// battery_power is positive while discharging and negative while charging,
+// grid_power is positive while importing and negative while exporting, and
+// load_power is home consumption.  The breakdown assumes solar supplies the
+// home first, then the battery, then the grid, and that the battery supplies
+// the home before exporting.
+type FlowBreakdown struct {
+	SolarToHome    float64 `json:"solar_to_home"`
+	SolarToBattery float64 `json:"solar_to_battery"`
+	SolarToGrid    float64 `json:"solar_to_grid"`
+	BatteryToHome  float64 `json:"battery_to_home"`
+	BatteryToGrid  float64 `json:"battery_to_grid"`
+	GridToHome     float64 `json:"grid_to_home"`
+	GridToBattery  float64 `json:"grid_to_battery"`
+
+	// Imbalance is solar + battery + grid - load as reported.  Power in
+	// should equal power out, so a large imbalance points to a meter
+	// problem, such as a miswired or failed CT.
+	Imbalance float64 `json:"imbalance"`
+
+	// Load is the home consumption the breakdown was computed for.
+	Load float64 `json:"load"`
+}
+
+// NewFlowBreakdown decomposes a live_status snapshot.  It fails if the
+// snapshot doesn't include solar, battery, grid and load power.  Negative
+// solar and load readings (meter noise at night) are treated as zero.
+func NewFlowBreakdown(status *LiveStatus) (*FlowBreakdown, error) {
+	if status.SolarPower == nil || status.BatteryPower == nil || status.GridPower == nil || status.LoadPower == nil {
+		return nil, fmt.Errorf("live_status did not include solar_power, battery_power, grid_power and load_power")
+	}
+	solar := math.Max(*status.SolarPower, 0)
+	battery := *status.BatteryPower
+	grid := *status.GridPower
+	load := math.Max(*status.LoadPower, 0)
+
+	f := &FlowBreakdown{
+		Imbalance: *status.SolarPower + battery + grid - *status.LoadPower,
+		Load:      load,
+	}
+
+	// Solar supplies the home first
+	f.SolarToHome = math.Min(solar, load)
+	solarLeft := solar - f.SolarToHome
+	loadLeft := load - f.SolarToHome
+
+	if battery < 0 {
+		// Charging: from solar first, and from the grid for the rest
+		charge := -battery
+		f.SolarToBattery = math.Min(solarLeft, charge)
+		solarLeft -= f.SolarToBattery
+		f.GridToBattery = math.Min(charge-f.SolarToBattery, math.Max(grid, 0))
+	} else {
+		// Discharging: to the home first, and to the grid for the rest
+		f.BatteryToHome = math.Min(battery, loadLeft)
+		loadLeft -= f.BatteryToHome
+		f.BatteryToGrid = battery - f.BatteryToHome
+	}
+
+	f.SolarToGrid = solarLeft
+	f.GridToHome = math.Min(loadLeft, math.Max(grid, 0)-f.GridToBattery)
+	f.GridToHome = math.Max(f.GridToHome, 0)
+	return f, nil
+}
+
+// Balanced returns true if the imbalance is within FlowBalanceTolerance plus
+// FlowBalanceToleranceFraction of the load.
+func (f *FlowBreakdown) Balanced() bool {
+	return math.Abs(f.Imbalance) <= FlowBalanceTolerance+FlowBalanceToleranceFraction*f.Load
+}
+
+// Solar returns the total solar production accounted for by the breakdown.
+func (f *FlowBreakdown) Solar() float64 {
+	return f.SolarToHome + f.SolarToBattery + f.SolarToGrid
+}
+
+// Home returns the total home consumption accounted for by the breakdown.
+func (f *FlowBreakdown) Home() float64 {
+	return f.SolarToHome + f.BatteryToHome + f.GridToHome
+}
+
+func (f *FlowBreakdown) String() string {
+	s := fmt.Sprintf("solar→home %.0f W, solar→battery %.0f W, solar→grid %.0f W, battery→home %.0f W, battery→grid %.0f W, grid→home %.0f W, grid→battery %.0f W",
+		f.SolarToHome, f.SolarToBattery, f.SolarToGrid, f.BatteryToHome, f.BatteryToGrid, f.GridToHome, f.GridToBattery)
+	if !f.Balanced() {
+		s += fmt.Sprintf(" (unbalanced by %.0f W)", f.Imbalance)
+	}
+	return s
+}
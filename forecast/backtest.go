@@ -0,0 +1,135 @@
+package forecast
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/blampe/powerwall/optimizer"
+)
+
+// Score summarizes how well forecasts matched held-out history.
+type Score struct {
+	Days  int
+	Load  Metrics
+	Solar Metrics
+}
+
+// Metrics are error statistics in kWh per slot.
+type Metrics struct {
+	Count    int     // Number of slots scored
+	MAE      float64 // Mean absolute error
+	RMSE     float64 // Root mean squared error
+	Bias     float64 // Mean of forecast minus actual
+	Coverage float64 // Fraction of actual values within the confidence band
+}
+
+// metricsAccumulator collects errors for Metrics.
+type metricsAccumulator struct {
+	count                   int
+	absSum, sqSum, sum, hit float64
+}
+
+func (a *metricsAccumulator) add(estimate Estimate, actual float64) {
+	err := estimate.Expected - actual
+	a.count++
+	a.absSum += math.Abs(err)
+	a.sqSum += err * err
+	a.sum += err
+	if actual >= estimate.Low && actual <= estimate.High {
+		a.hit++
+	}
+}
+
+func (a *metricsAccumulator) metrics() Metrics {
+	if a.count == 0 {
+		return Metrics{}
+	}
+	n := float64(a.count)
+	return Metrics{
+		Count:    a.count,
+		MAE:      a.absSum / n,
+		RMSE:     math.Sqrt(a.sqSum / n),
+		Bias:     a.sum / n,
+		Coverage: a.hit / n,
+	}
+}
+
+// Backtest scores day-ahead forecasts against the last holdoutDays days of
+// observations.  Each held-out day is forecast from a model built only from
+// the observations before it, as it would have been at the time.
+func Backtest(observations []Observation, loc *time.Location, holdoutDays int, options ...func(m *Model)) (*Score, error) {
+	if len(observations) == 0 {
+		return nil, fmt.Errorf("no observations to backtest")
+	}
+	if holdoutDays < 1 {
+		return nil, fmt.Errorf("at least one held-out day is needed, got %d", holdoutDays)
+	}
+
+	actual := make(map[time.Time]Observation, len(observations))
+	last := observations[0].Start
+	for _, o := range observations {
+		actual[o.Start] = o
+		if o.Start.After(last) {
+			last = o.Start
+		}
+	}
+
+	// Hold out whole days, ending with the last complete one
+	end := startOfDay(last.Add(SlotDuration), loc)
+	first := end.AddDate(0, 0, -holdoutDays)
+
+	var load, solar metricsAccumulator
+	days := 0
+	for day := first; day.Before(end); day = day.AddDate(0, 0, 1) {
+		var training []Observation
+		for _, o := range observations {
+			if o.Start.Before(day) {
+				training = append(training, o)
+			}
+		}
+		if len(training) == 0 {
+			continue
+		}
+
+		next := day.AddDate(0, 0, 1)
+		intervals, err := New(training, loc, options...).Forecast(day, int(next.Sub(day)/SlotDuration))
+		if err != nil {
+			return nil, fmt.Errorf("forecasting %s: %w", day.Format("2006-01-02"), err)
+		}
+
+		scored := false
+		for _, interval := range intervals {
+			o, ok := actual[interval.Start]
+			if !ok {
+				continue
+			}
+			load.add(interval.Load, o.LoadKWh)
+			solar.add(interval.Solar, o.SolarKWh)
+			scored = true
+		}
+		if scored {
+			days++
+		}
+	}
+
+	if days == 0 {
+		return nil, fmt.Errorf("not enough history before the held-out days to backtest")
+	}
+	return &Score{Days: days, Load: load.metrics(), Solar: solar.metrics()}, nil
+}
+
+// OptimizerForecast converts expected values to an optimizer.Forecast, so a
+// day-ahead forecast can drive the charge optimizer.
+func OptimizerForecast(intervals []Interval) optimizer.Forecast {
+	result := make(optimizer.Forecast, 0, len(intervals))
+	for _, interval := range intervals {
+		result = append(result, optimizer.Interval{
+			Start:    interval.Start,
+			Duration: SlotDuration,
+			LoadWh:   interval.Load.Expected * 1000,
+			SolarWh:  interval.Solar.Expected * 1000,
+		})
+	}
+	return result
+}
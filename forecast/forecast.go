@@ -0,0 +1,205 @@
+// Package forecast estimates household load and solar production from
+// history.
+//
+// Load follows the week, so it is modelled with a profile for every weekday
+// and 15-minute slot.  Solar follows the seasons instead: each slot is
+// estimated from days within a few weeks of the same day of the year,
+// including previous years when the history reaches back that far.  Every
+// estimate comes with a confidence band taken from the spread of the history
+// it was built from:
+//
+//	observations, _ := forecast.Fetch(client, time.Now().AddDate(0, 0, -28), time.Now(), loc)
+//	model := forecast.New(observations, loc)
+//	intervals, _ := model.Forecast(tomorrow, 96)
+//
+//	score, _ := forecast.Backtest(observations, loc, 7)
+package forecast
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// SlotDuration is the length of each forecast interval.
+	SlotDuration = 15 * time.Minute
+
+	slotsPerDay = int(24 * time.Hour / SlotDuration)
+
+	// DefaultSolarWindow is how many days either side of a date's day of the
+	// year are used for its solar estimate.
+	DefaultSolarWindow = 14
+
+	// DefaultLowQuantile and DefaultHighQuantile bound the confidence band.
+	DefaultLowQuantile  = 0.1
+	DefaultHighQuantile = 0.9
+)
+
+// Estimate is an expected energy in kWh with a confidence band.
+type Estimate struct {
+	Expected float64 `json:"expected"`
+	Low      float64 `json:"low"`
+	High     float64 `json:"high"`
+}
+
+// Interval is the forecast for one slot.
+type Interval struct {
+	Start time.Time `json:"start"`
+	Load  Estimate  `json:"load_kwh"`
+	Solar Estimate  `json:"solar_kwh"`
+}
+
+// Model holds the load and solar profiles built from history.
+type Model struct {
+	loc          *time.Location
+	solarWindow  int
+	lowQuantile  float64
+	highQuantile float64
+
+	load  [7][slotsPerDay][]float64
+	solar [slotsPerDay][]solarSample
+}
+
+// solarSample is the solar production of one slot on a given day of the year.
+type solarSample struct {
+	dayOfYear int
+	kwh       float64
+}
+
+// New builds a Model from observations.  Slots are taken in loc, which should
+// be the site's installation time zone.
+func New(observations []Observation, loc *time.Location, options ...func(m *Model)) *Model {
+	m := &Model{
+		loc:          loc,
+		solarWindow:  DefaultSolarWindow,
+		lowQuantile:  DefaultLowQuantile,
+		highQuantile: DefaultHighQuantile,
+	}
+
+	// Apply options
+	for _, option := range options {
+		if option != nil {
+			option(m)
+		}
+	}
+
+	for _, o := range observations {
+		weekday, slot := m.key(o.Start)
+		m.load[weekday][slot] = append(m.load[weekday][slot], o.LoadKWh)
+		m.solar[slot] = append(m.solar[slot], solarSample{o.Start.In(loc).YearDay(), o.SolarKWh})
+	}
+
+	return m
+}
+
+// WithSolarWindow sets how many days either side of the day of the year are
+// used for solar estimates
+func WithSolarWindow(days int) func(m *Model) {
+	return func(m *Model) {
+		m.solarWindow = days
+	}
+}
+
+// WithConfidence sets the quantiles of the confidence band, e.g. 0.1 and 0.9
+func WithConfidence(low, high float64) func(m *Model) {
+	return func(m *Model) {
+		m.lowQuantile = low
+		m.highQuantile = high
+	}
+}
+
+// Forecast returns estimates for n consecutive slots from the slot containing
+// start.  It fails if the history has no load data for one of the slots.
+func (m *Model) Forecast(start time.Time, n int) ([]Interval, error) {
+	t := slotStart(start, m.loc)
+	intervals := make([]Interval, 0, n)
+	for i := 0; i < n; i++ {
+		weekday, slot := m.key(t)
+
+		// Neighbouring slots are pooled to smooth the load profile
+		var load []float64
+		for _, s := range []int{slot - 1, slot, slot + 1} {
+			load = append(load, m.load[weekday][(s+slotsPerDay)%slotsPerDay]...)
+		}
+		if len(load) == 0 {
+			return nil, fmt.Errorf("no load history for %s %s", t.Weekday(), t.Format("15:04"))
+		}
+
+		var solar []float64
+		day := t.YearDay()
+		for _, sample := range m.solar[slot] {
+			if dayDistance(sample.dayOfYear, day) <= m.solarWindow {
+				solar = append(solar, sample.kwh)
+			}
+		}
+
+		intervals = append(intervals, Interval{
+			Start: t,
+			Load:  m.estimate(load),
+			Solar: m.estimate(solar),
+		})
+		t = t.Add(SlotDuration)
+	}
+	return intervals, nil
+}
+
+// estimate returns the mean and confidence band of samples.  With no
+// samples the estimate is zero.
+func (m *Model) estimate(samples []float64) Estimate {
+	if len(samples) == 0 {
+		return Estimate{}
+	}
+	sorted := make([]float64, len(samples))
+	copy(sorted, samples)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	return Estimate{
+		Expected: sum / float64(len(sorted)),
+		Low:      quantile(sorted, m.lowQuantile),
+		High:     quantile(sorted, m.highQuantile),
+	}
+}
+
+// key returns the weekday and slot of the day of t in the model's location.
+func (m *Model) key(t time.Time) (time.Weekday, int) {
+	local := t.In(m.loc)
+	return local.Weekday(), (local.Hour()*60 + local.Minute()) / int(SlotDuration/time.Minute)
+}
+
+// quantile interpolates the q-th quantile of sorted values.
+func quantile(sorted []float64, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+// dayDistance returns the number of days between two days of the year,
+// wrapping around the end of the year.
+func dayDistance(a, b int) int {
+	d := a - b
+	if d < 0 {
+		d = -d
+	}
+	return min(d, 365-d)
+}
+
+// slotStart returns the start of the slot containing t, in loc.  Slots are
+// aligned to the local wall clock, which matters for time zones with
+// half-hour offsets.
+func slotStart(t time.Time, loc *time.Location) time.Time {
+	local := t.In(loc)
+	minutes := local.Minute() - local.Minute()%int(SlotDuration/time.Minute)
+	return time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), minutes, 0, 0, loc)
+}
+
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	local := t.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+}
@@ -0,0 +1,111 @@
+package forecast
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/blampe/powerwall"
+)
+
+// Observation is the measured load and solar production over one slot.
+type Observation struct {
+	Start    time.Time
+	LoadKWh  float64
+	SolarKWh float64
+}
+
+// Fetch downloads power history for [start, end) one day at a time and
+// converts it to observations.  Days are split in loc, which should be the
+// site's installation time zone.
+func Fetch(client *powerwall.Client, start, end time.Time, loc *time.Location) ([]Observation, error) {
+	return FetchContext(context.Background(), client, start, end, loc)
+}
+
+// FetchContext is like Fetch but uses ctx for cancellation and tracing.
+func FetchContext(ctx context.Context, client *powerwall.Client, start, end time.Time, loc *time.Location) ([]Observation, error) {
+	var points []powerwall.TimePoint
+	for day := startOfDay(start, loc); day.Before(end); day = day.AddDate(0, 0, 1) {
+		history, err := client.GetPowerHistoryContext(ctx, day.Format(time.RFC3339), day.AddDate(0, 0, 1).Format(time.RFC3339), "day")
+		if err != nil {
+			return nil, fmt.Errorf("fetching power history for %s: %w", day.Format("2006-01-02"), err)
+		}
+		for _, p := range history.TimeSeries {
+			if !p.Timestamp.Before(start) && p.Timestamp.Before(end) {
+				points = append(points, p)
+			}
+		}
+	}
+	return ObservationsFromHistory(points, loc), nil
+}
+
+// ObservationsFromHistory buckets history points into slots.  Power points
+// (from GetPowerHistory) are integrated over the time until the next point,
+// up to one slot; energy points (from GetEnergyHistory) are summed.  Load is
+// derived from the power or energy balance.
+func ObservationsFromHistory(points []powerwall.TimePoint, loc *time.Location) []Observation {
+	sorted := make([]powerwall.TimePoint, len(points))
+	copy(sorted, points)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+
+	bySlot := map[time.Time]*Observation{}
+	for i, p := range sorted {
+		var load, solar float64
+		if isPowerPoint(p) {
+			duration := SlotDuration
+			if i+1 < len(sorted) {
+				if gap := sorted[i+1].Timestamp.Sub(p.Timestamp); gap > 0 && gap < duration {
+					duration = gap
+				}
+			}
+			hours := duration.Hours()
+			solar = value(p.SolarPower) * hours / 1000
+			load = (value(p.SolarPower) + value(p.BatteryPower) + value(p.GridPower) + value(p.GeneratorPower)) * hours / 1000
+		} else {
+			solar = value(p.SolarEnergyExported) / 1000
+			load = energyLoad(p) / 1000
+		}
+
+		start := slotStart(p.Timestamp, loc)
+		o, ok := bySlot[start]
+		if !ok {
+			o = &Observation{Start: start}
+			bySlot[start] = o
+		}
+		o.LoadKWh += max(load, 0)
+		o.SolarKWh += max(solar, 0)
+	}
+
+	observations := make([]Observation, 0, len(bySlot))
+	for _, o := range bySlot {
+		observations = append(observations, *o)
+	}
+	sort.Slice(observations, func(i, j int) bool {
+		return observations[i].Start.Before(observations[j].Start)
+	})
+	return observations
+}
+
+func isPowerPoint(p powerwall.TimePoint) bool {
+	return p.SolarPower != nil || p.BatteryPower != nil || p.GridPower != nil
+}
+
+// energyLoad returns the household consumption of an energy history point,
+// derived from the energy balance when the API doesn't report it.
+func energyLoad(p powerwall.TimePoint) float64 {
+	if p.ConsumerEnergyImported != nil {
+		return *p.ConsumerEnergyImported
+	}
+	return value(p.SolarEnergyExported) + value(p.GridEnergyImported) - value(p.GridEnergyExported) +
+		value(p.BatteryEnergyExported) - value(p.BatteryEnergyImported)
+}
+
+func value(v *float64) float64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
@@ -0,0 +1,76 @@
+// Detection of stale live_status data
+//
+//	WithStaleThreshold(threshold) - Age at which live_status data is stale
+//
+// When a gateway loses its connection to Tesla, the Fleet API keeps serving
+// the last live_status it received.  The live_status methods compare its
+// timestamp with the server's clock, taken from the Date header of Fleet API
+// responses, and return a StaleDataError once it is older than the threshold.
+
+package powerwall
+
+import (
+	"net/http"
+	"time"
+)
+
+// DefaultStaleThreshold is the age at which live_status data is reported as
+// stale unless WithStaleThreshold overrides it.  Gateways normally report
+// every few seconds.
+const DefaultStaleThreshold = 5 * time.Minute
+
+// WithStaleThreshold sets the age at which live_status data is reported as
+// stale with a StaleDataError.  A threshold of zero disables the check.
+func WithStaleThreshold(threshold time.Duration) func(c *Client) {
+	return func(c *Client) {
+		c.staleThreshold = threshold
+	}
+}
+
+// observeServerTime records the offset between the server's clock, from a
+// response's Date header, and ours.
+func (c *Client) observeServerTime(header http.Header, received time.Time) {
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		return
+	}
+
+	c.clockMutex.Lock()
+	defer c.clockMutex.Unlock()
+
+	c.serverClockOffset = date.Sub(received)
+}
+
+// serverTime returns the current time by the Fleet API's clock, or ours if
+// no response has carried a Date header yet.
+func (c *Client) serverTime() time.Time {
+	c.clockMutex.Lock()
+	defer c.clockMutex.Unlock()
+
+	return time.Now().Add(c.serverClockOffset)
+}
+
+// checkFreshness returns a StaleDataError if status is older than the stale
+// threshold.  Data without a timestamp is assumed to be fresh.
+func (c *Client) checkFreshness(status *LiveStatus) error {
+	if c.staleThreshold <= 0 || status.Timestamp.IsZero() {
+		return nil
+	}
+
+	now := c.serverTime()
+	age := now.Sub(status.Timestamp)
+	if age <= c.staleThreshold {
+		return nil
+	}
+
+	c.logf("Live status is stale: timestamp=%s age=%s", status.Timestamp.Format(time.RFC3339), age.Round(time.Second))
+	stale := *status
+	return StaleDataError{
+		EnergyProductID: c.selectedSiteID,
+		Timestamp:       status.Timestamp,
+		ServerTime:      now,
+		Age:             age,
+		Threshold:       c.staleThreshold,
+		Status:          &stale,
+	}
+}
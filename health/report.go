@@ -0,0 +1,174 @@
+package health
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// NominalCapacityWh is the nameplate energy of a single Powerwall.
+	NominalCapacityWh = 13500
+
+	// DefaultWarrantyThreshold is the fraction of nameplate capacity Tesla's
+	// warranty guarantees at the end of the warranty period.
+	DefaultWarrantyThreshold = 0.7
+
+	// MaxProjectionYears is how far ahead the warranty threshold is
+	// projected.  Early, noisy samples can give slopes which project
+	// centuries ahead, which says nothing useful.
+	MaxProjectionYears = 100
+
+	hoursPerYear = 365.25 * 24
+)
+
+// Report summarizes the capacity fade of one energy site.
+type Report struct {
+	Samples int       `json:"samples"`
+	First   time.Time `json:"first"`
+	Last    time.Time `json:"last"`
+
+	// NameplateWh is BatteryCount × NominalCapacityWh, using the battery
+	// count of the latest sample.
+	NameplateWh       float64 `json:"nameplate_wh"`
+	NominalCapacityWh float64 `json:"nominal_capacity_wh"`
+	BatteryCount      int     `json:"battery_count"`
+
+	// CapacityWh is the latest sample, and Retained the fraction of nameplate
+	// capacity it represents.  Fade is 1 - Retained.
+	CapacityWh float64 `json:"capacity_wh"`
+	Retained   float64 `json:"retained"`
+	Fade       float64 `json:"fade"`
+
+	// Trend is the least-squares fit of capacity against time.  It is nil
+	// with fewer than two samples.
+	Trend *Trend `json:"trend,omitempty"`
+
+	// Threshold is the warranty threshold as a fraction of nameplate
+	// capacity, and ThresholdDate the date on which the trend crosses it.
+	// ThresholdDate is nil if capacity isn't trending down, or if the
+	// projection is more than MaxProjectionYears from the first sample.
+	Threshold     float64    `json:"threshold"`
+	ThresholdDate *time.Time `json:"threshold_date,omitempty"`
+}
+
+// Trend is a linear fit of capacity over time.
+type Trend struct {
+	// SlopeWhPerYear is the change in capacity per year; negative values
+	// mean the pack is losing capacity.
+	SlopeWhPerYear float64 `json:"slope_wh_per_year"`
+
+	// FadePerYear is the capacity lost per year as a fraction of nameplate.
+	FadePerYear float64 `json:"fade_per_year"`
+
+	origin    time.Time
+	intercept float64
+}
+
+// At returns the capacity in Wh the trend predicts at the given time.
+func (t *Trend) At(at time.Time) float64 {
+	return t.intercept + t.SlopeWhPerYear*at.Sub(t.origin).Hours()/hoursPerYear
+}
+
+// WithNominalCapacity sets the nameplate energy of a single battery, for
+// batteries other than the 13.5 kWh Powerwall
+func WithNominalCapacity(wh float64) func(r *Report) {
+	return func(r *Report) {
+		r.NominalCapacityWh = wh
+	}
+}
+
+// WithWarrantyThreshold sets the fraction of nameplate capacity the warranty
+// guarantees, e.g. 0.7
+func WithWarrantyThreshold(fraction float64) func(r *Report) {
+	return func(r *Report) {
+		r.Threshold = fraction
+	}
+}
+
+// Analyze computes the capacity fade and trend of a site's samples, which
+// must be in chronological order as returned by Store.Samples.
+func Analyze(samples []Sample, options ...func(r *Report)) (*Report, error) {
+	report := &Report{
+		NominalCapacityWh: NominalCapacityWh,
+		Threshold:         DefaultWarrantyThreshold,
+	}
+
+	// Apply options
+	for _, option := range options {
+		if option != nil {
+			option(report)
+		}
+	}
+
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("no capacity samples")
+	}
+	last := samples[len(samples)-1]
+	if last.BatteryCount <= 0 {
+		return nil, fmt.Errorf("latest sample has no battery count")
+	}
+
+	report.Samples = len(samples)
+	report.First = samples[0].Time
+	report.Last = last.Time
+	report.BatteryCount = last.BatteryCount
+	report.NameplateWh = float64(last.BatteryCount) * report.NominalCapacityWh
+	report.CapacityWh = last.TotalPackEnergyWh
+	report.Retained = report.CapacityWh / report.NameplateWh
+	report.Fade = 1 - report.Retained
+
+	// Batteries added or removed change the capacity by far more than fade
+	// does, so only samples with the current battery count are fitted
+	var fitted []Sample
+	for _, s := range samples {
+		if s.BatteryCount == last.BatteryCount {
+			fitted = append(fitted, s)
+		}
+	}
+	report.Trend = fit(fitted)
+	if report.Trend == nil {
+		return report, nil
+	}
+	report.Trend.FadePerYear = -report.Trend.SlopeWhPerYear / report.NameplateWh
+
+	if report.Trend.SlopeWhPerYear < 0 {
+		target := report.Threshold * report.NameplateWh
+		years := (target - report.Trend.intercept) / report.Trend.SlopeWhPerYear
+		if math.Abs(years) <= MaxProjectionYears {
+			date := report.Trend.origin.Add(time.Duration(years * hoursPerYear * float64(time.Hour)))
+			report.ThresholdDate = &date
+		}
+	}
+	return report, nil
+}
+
+// fit returns the least-squares line through the samples, or nil if they
+// don't span any time.
+func fit(samples []Sample) *Trend {
+	if len(samples) < 2 {
+		return nil
+	}
+
+	origin := samples[0].Time
+	n := float64(len(samples))
+	var sumX, sumY, sumXX, sumXY float64
+	for _, s := range samples {
+		x := s.Time.Sub(origin).Hours() / hoursPerYear
+		sumX += x
+		sumY += s.TotalPackEnergyWh
+		sumXX += x * x
+		sumXY += x * s.TotalPackEnergyWh
+	}
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return nil
+	}
+
+	slope := (n*sumXY - sumX*sumY) / denominator
+	return &Trend{
+		SlopeWhPerYear: slope,
+		origin:         origin,
+		intercept:      (sumY - slope*sumX) / n,
+	}
+}
@@ -0,0 +1,151 @@
+// Package health tracks battery degradation.
+//
+// A Sampler records the usable capacity (total_pack_energy from live_status)
+// of each energy site once a day in a Store.  Analyze compares the samples
+// with the nameplate capacity of the installed batteries and fits a linear
+// trend, which projects when capacity will cross the warranty threshold:
+//
+//	store, _ := health.OpenStore("health.json")
+//	sampler := health.NewSampler(client, store)
+//	go sampler.Run(ctx)
+//
+//	report, _ := health.Analyze(store.Samples(siteID))
+//	if report.ThresholdDate != nil {
+//		fmt.Println("Warranty threshold reached around", report.ThresholdDate)
+//	}
+//
+// Reported capacity moves with temperature and state of charge, so the trend
+// is only meaningful after a few months of samples.
+package health
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/blampe/powerwall"
+	"github.com/blampe/powerwall/metrics"
+)
+
+// DefaultInterval is the default time between capacity samples.
+const DefaultInterval = 24 * time.Hour
+
+// Sampler records the usable capacity of energy sites in a Store.
+type Sampler struct {
+	client     *powerwall.Client
+	store      *Store
+	siteIDs    []int64
+	interval   time.Duration
+	errHandler func(error)
+}
+
+// NewSampler creates a Sampler which records samples in store.
+func NewSampler(client *powerwall.Client, store *Store, options ...func(s *Sampler)) *Sampler {
+	s := &Sampler{
+		client:     client,
+		store:      store,
+		interval:   DefaultInterval,
+		errHandler: func(error) {},
+	}
+
+	// Apply options
+	for _, option := range options {
+		if option != nil {
+			option(s)
+		}
+	}
+
+	return s
+}
+
+// WithSites restricts the sampler to the given energy site IDs.  By default
+// all energy sites on the account are sampled.
+func WithSites(siteIDs ...int64) func(s *Sampler) {
+	return func(s *Sampler) {
+		s.siteIDs = siteIDs
+	}
+}
+
+// WithInterval sets the time between samples
+func WithInterval(interval time.Duration) func(s *Sampler) {
+	return func(s *Sampler) {
+		s.interval = interval
+	}
+}
+
+// WithErrorHandler registers a callback for sites which couldn't be sampled
+// or saved, wrapped with the site ID.  Such a site has no sample for the
+// current interval.
+func WithErrorHandler(f func(error)) func(s *Sampler) {
+	return func(s *Sampler) {
+		s.errHandler = f
+	}
+}
+
+// Run samples every site until ctx is cancelled.  The sampler selects each
+// site in turn, so the client must not be used by anything else while Run is
+// active.
+func (s *Sampler) Run(ctx context.Context) error {
+	sites, err := metrics.ResolveSitesContext(ctx, s.client, s.siteIDs)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		for _, site := range sites {
+			if err := s.sampleSite(ctx, site.ID); err != nil {
+				s.errHandler(fmt.Errorf("site %d: %w", site.ID, err))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sample records the current capacity of every site once.
+func (s *Sampler) Sample() error {
+	sites, err := metrics.ResolveSites(s.client, s.siteIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, site := range sites {
+		if err := s.sampleSite(context.Background(), site.ID); err != nil {
+			return fmt.Errorf("site %d: %w", site.ID, err)
+		}
+	}
+	return nil
+}
+
+// sampleSite records the current capacity of a single site.
+func (s *Sampler) sampleSite(ctx context.Context, siteID int64) error {
+	if err := s.client.SelectEnergySite(siteID); err != nil {
+		return err
+	}
+
+	status, err := s.client.GetLiveStatusContext(ctx)
+	if err != nil {
+		return err
+	}
+	if status.TotalPackEnergy == nil {
+		return fmt.Errorf("live_status did not include total_pack_energy")
+	}
+
+	cfg, err := s.client.GetSiteConfigContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.store.Add(siteID, Sample{
+		Time:              time.Now(),
+		TotalPackEnergyWh: *status.TotalPackEnergy,
+		BatteryCount:      cfg.BatteryCount,
+	})
+}
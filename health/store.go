@@ -0,0 +1,86 @@
+package health
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/blampe/powerwall/internal/jsonfile"
+)
+
+// Sample is a measurement of a site's usable battery capacity.
+type Sample struct {
+	Time time.Time `json:"time"`
+
+	// TotalPackEnergyWh is the total_pack_energy reported by live_status.
+	TotalPackEnergyWh float64 `json:"total_pack_energy_wh"`
+	BatteryCount      int     `json:"battery_count"`
+}
+
+// Store keeps capacity samples for each energy site, optionally persisted to
+// a JSON file.  At most one sample is kept per site per day.
+type Store struct {
+	path string
+
+	mu      sync.Mutex
+	samples map[int64][]Sample
+}
+
+// OpenStore opens the store at path, creating it on the first write if it
+// doesn't exist.  An empty path gives a store which is only kept in memory.
+func OpenStore(path string) (*Store, error) {
+	s := &Store{path: path, samples: map[int64][]Sample{}}
+	if path == "" {
+		return s, nil
+	}
+
+	if err := jsonfile.Read(path, &s.samples); err != nil {
+		return nil, fmt.Errorf("reading health store %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Samples returns the samples of an energy site, oldest first.
+func (s *Store) Samples(siteID int64) []Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	samples := make([]Sample, len(s.samples[siteID]))
+	copy(samples, s.samples[siteID])
+	return samples
+}
+
+// Add records a sample for an energy site.  A sample from the same day as an
+// existing one replaces it, so sampling more than once a day keeps only the
+// latest measurement.
+func (s *Store) Add(siteID int64, sample Sample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	day := sample.Time.Format("2006-01-02")
+	samples := s.samples[siteID]
+	replaced := false
+	for i := range samples {
+		if samples[i].Time.Format("2006-01-02") == day {
+			samples[i] = sample
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		samples = append(samples, sample)
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].Time.Before(samples[j].Time)
+	})
+	s.samples[siteID] = samples
+
+	if s.path == "" {
+		return nil
+	}
+	if err := jsonfile.Write(s.path, s.samples); err != nil {
+		return fmt.Errorf("saving health store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// Package jsonfile reads and writes the JSON state files kept by the polling
+// packages.
+//
+//	Read(path, &v)  - Decodes path into v, leaving v unchanged if path doesn't exist
+//	Write(path, v)  - Replaces path with v, atomically
+package jsonfile
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// Read decodes the JSON file at path into v.  A missing file isn't an error;
+// v is left unchanged, so the caller's defaults apply.
+func Read(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Write encodes v as indented JSON to a temporary file in the same directory
+// as path, then renames it over path.  Readers see either the old contents or
+// the new, never a partial write.
+func Write(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
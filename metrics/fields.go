@@ -0,0 +1,59 @@
+package metrics
+
+import "github.com/blampe/powerwall"
+
+// field is a single numeric value extracted from a snapshot or history point
+// for the push-based sinks.
+type field struct {
+	name  string // Matches the Fleet API JSON key
+	unit  string // UCUM unit, as used by OTLP
+	value float64
+}
+
+// snapshotFields returns the numeric values of a snapshot.  Power and energy
+// values the gateway didn't report are omitted; booleans are reported as 0/1.
+func snapshotFields(snapshot Snapshot) []field {
+	status := snapshot.LiveStatus
+
+	var fields []field
+	optional := func(name, unit string, v *float64) {
+		if v != nil {
+			fields = append(fields, field{name, unit, *v})
+		}
+	}
+	optional("solar_power", "W", status.SolarPower)
+	optional("battery_power", "W", status.BatteryPower)
+	optional("grid_power", "W", status.GridPower)
+	optional("load_power", "W", status.LoadPower)
+	optional("percentage_charged", "%", status.PercentageCharged)
+	optional("energy_left", "W.h", status.EnergyLeft)
+	optional("total_pack_energy", "W.h", status.TotalPackEnergy)
+
+	return append(fields,
+		field{"storm_mode_active", "1", boolToFloat(status.StormModeActive)},
+		field{"grid_services_active", "1", boolToFloat(status.GridServicesActive)},
+		field{"backup_reserve_percent", "%", float64(snapshot.BackupReserve)},
+	)
+}
+
+// timePointFields returns the values present in a history point.
+func timePointFields(point powerwall.TimePoint) []field {
+	var fields []field
+	optional := func(name, unit string, v *float64) {
+		if v != nil {
+			fields = append(fields, field{name, unit, *v})
+		}
+	}
+	optional("solar_power", "W", point.SolarPower)
+	optional("battery_power", "W", point.BatteryPower)
+	optional("grid_power", "W", point.GridPower)
+	optional("grid_services_power", "W", point.GridServicesPower)
+	optional("generator_power", "W", point.GeneratorPower)
+	optional("solar_energy_exported", "W.h", point.SolarEnergyExported)
+	optional("grid_energy_imported", "W.h", point.GridEnergyImported)
+	optional("grid_energy_exported", "W.h", point.GridEnergyExported)
+	optional("battery_energy_exported", "W.h", point.BatteryEnergyExported)
+	optional("battery_energy_imported", "W.h", point.BatteryEnergyImported)
+	optional("consumer_energy_imported", "W.h", point.ConsumerEnergyImported)
+	return fields
+}
@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blampe/powerwall"
+)
+
+// InfluxSink writes snapshots and history points to InfluxDB using the line
+// protocol over HTTP.  Snapshots are written to the "powerwall_live"
+// measurement and history points to "powerwall_history", tagged with site_id
+// and site_name.
+type InfluxSink struct {
+	writeURL   string
+	token      string
+	httpClient *http.Client
+}
+
+// NewInfluxSink creates a sink which POSTs to writeURL.  This is the complete
+// write endpoint including query parameters, for example
+// "http://localhost:8086/api/v2/write?org=home&bucket=powerwall&precision=ns"
+// for InfluxDB 2.x or "http://localhost:8086/write?db=powerwall" for 1.x.
+func NewInfluxSink(writeURL string, options ...func(s *InfluxSink)) *InfluxSink {
+	s := &InfluxSink{
+		writeURL:   writeURL,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+
+	// Apply options
+	for _, option := range options {
+		if option != nil {
+			option(s)
+		}
+	}
+
+	return s
+}
+
+// WithInfluxToken sets the API token sent in the Authorization header
+func WithInfluxToken(token string) func(s *InfluxSink) {
+	return func(s *InfluxSink) {
+		s.token = token
+	}
+}
+
+// WithInfluxHttpClient sets the HTTP client used for writes
+func WithInfluxHttpClient(httpClient *http.Client) func(s *InfluxSink) {
+	return func(s *InfluxSink) {
+		s.httpClient = httpClient
+	}
+}
+
+// WriteSnapshot writes a single "powerwall_live" point.
+func (s *InfluxSink) WriteSnapshot(ctx context.Context, snapshot Snapshot) error {
+	var buf bytes.Buffer
+	status := snapshot.LiveStatus
+
+	writeInfluxPrefix(&buf, "powerwall_live", snapshot.Site)
+	for i, f := range snapshotFields(snapshot) {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeInfluxFloat(&buf, f)
+	}
+	fmt.Fprintf(&buf, `,grid_status="%s",island_status="%s"`,
+		escapeInfluxString(status.GridStatus), escapeInfluxString(status.IslandStatus))
+	fmt.Fprintf(&buf, " %d\n", status.Timestamp.UnixNano())
+
+	return s.write(ctx, buf.Bytes())
+}
+
+// WriteHistory writes one "powerwall_history" point per history entry.
+func (s *InfluxSink) WriteHistory(ctx context.Context, site Site, history *powerwall.HistoryData) error {
+	var buf bytes.Buffer
+	for _, point := range history.TimeSeries {
+		fields := timePointFields(point)
+		if len(fields) == 0 {
+			// Line protocol requires at least one field
+			continue
+		}
+
+		writeInfluxPrefix(&buf, "powerwall_history", site)
+		for i, f := range fields {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeInfluxFloat(&buf, f)
+		}
+		fmt.Fprintf(&buf, " %d\n", point.Timestamp.UnixNano())
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	return s.write(ctx, buf.Bytes())
+}
+
+func (s *InfluxSink) write(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", s.writeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("InfluxDB write returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+// writeInfluxPrefix writes the measurement, the site tags and the separating
+// space before the field set.
+func writeInfluxPrefix(buf *bytes.Buffer, measurement string, site Site) {
+	buf.WriteString(measurement)
+	buf.WriteString(",site_id=")
+	buf.WriteString(strconv.FormatInt(site.ID, 10))
+	if site.Name != "" {
+		buf.WriteString(",site_name=")
+		buf.WriteString(influxTagEscaper.Replace(site.Name))
+	}
+	buf.WriteByte(' ')
+}
+
+func writeInfluxFloat(buf *bytes.Buffer, f field) {
+	buf.WriteString(f.name)
+	buf.WriteByte('=')
+	buf.WriteString(strconv.FormatFloat(f.value, 'f', -1, 64))
+}
+
+var influxTagEscaper = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `)
+
+func escapeInfluxString(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
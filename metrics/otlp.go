@@ -0,0 +1,231 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blampe/powerwall"
+)
+
+// OTLPSink writes snapshots and history points as OTLP gauge metrics using the
+// OTLP/HTTP JSON encoding.  Metric names are prefixed with "powerwall." and
+// every data point carries site_id and site_name attributes.  Snapshot metrics
+// use the live_status field names (e.g. "powerwall.solar_power") and history
+// metrics are prefixed with "powerwall.history.".
+type OTLPSink struct {
+	endpoint    string
+	headers     map[string]string
+	serviceName string
+	httpClient  *http.Client
+}
+
+// NewOTLPSink creates a sink which POSTs to the given OTLP/HTTP metrics
+// endpoint, for example "http://localhost:4318/v1/metrics".
+func NewOTLPSink(endpoint string, options ...func(s *OTLPSink)) *OTLPSink {
+	s := &OTLPSink{
+		endpoint:    endpoint,
+		headers:     map[string]string{},
+		serviceName: "powerwall",
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+
+	// Apply options
+	for _, option := range options {
+		if option != nil {
+			option(s)
+		}
+	}
+
+	return s
+}
+
+// WithOTLPHeader adds a header (such as an API key) to every export request
+func WithOTLPHeader(key, value string) func(s *OTLPSink) {
+	return func(s *OTLPSink) {
+		s.headers[key] = value
+	}
+}
+
+// WithOTLPServiceName sets the service.name resource attribute
+func WithOTLPServiceName(name string) func(s *OTLPSink) {
+	return func(s *OTLPSink) {
+		s.serviceName = name
+	}
+}
+
+// WithOTLPHttpClient sets the HTTP client used for exports
+func WithOTLPHttpClient(httpClient *http.Client) func(s *OTLPSink) {
+	return func(s *OTLPSink) {
+		s.httpClient = httpClient
+	}
+}
+
+// The types below are the subset of the OTLP metrics JSON encoding needed to
+// export gauges.  See opentelemetry-proto's metrics_service.proto.
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name  string    `json:"name"`
+	Unit  string    `json:"unit,omitempty"`
+	Gauge otlpGauge `json:"gauge"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpDataPoint struct {
+	Attributes   []otlpAttribute `json:"attributes"`
+	TimeUnixNano string          `json:"timeUnixNano"`
+	AsDouble     float64         `json:"asDouble"`
+}
+
+type otlpAttribute struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// WriteSnapshot exports one data point per snapshot field.  The grid and
+// island status are exported as "powerwall.grid_status" and
+// "powerwall.island_status" gauges of 1 with a "status" attribute.
+func (s *OTLPSink) WriteSnapshot(ctx context.Context, snapshot Snapshot) error {
+	status := snapshot.LiveStatus
+	attrs := otlpSiteAttributes(snapshot.Site)
+	ts := strconv.FormatInt(status.Timestamp.UnixNano(), 10)
+
+	var metrics []otlpMetric
+	for _, f := range snapshotFields(snapshot) {
+		metrics = append(metrics, otlpMetric{
+			Name: "powerwall." + f.name,
+			Unit: f.unit,
+			Gauge: otlpGauge{DataPoints: []otlpDataPoint{
+				{Attributes: attrs, TimeUnixNano: ts, AsDouble: f.value},
+			}},
+		})
+	}
+	for _, state := range [][2]string{{"grid_status", status.GridStatus}, {"island_status", status.IslandStatus}} {
+		name, value := state[0], state[1]
+		metrics = append(metrics, otlpMetric{
+			Name: "powerwall." + name,
+			Unit: "1",
+			Gauge: otlpGauge{DataPoints: []otlpDataPoint{{
+				Attributes:   append(otlpSiteAttributes(snapshot.Site), otlpAttribute{"status", otlpAnyValue{value}}),
+				TimeUnixNano: ts,
+				AsDouble:     1,
+			}}},
+		})
+	}
+
+	return s.export(ctx, metrics)
+}
+
+// WriteHistory exports every history entry as data points of the
+// "powerwall.history.*" gauges.
+func (s *OTLPSink) WriteHistory(ctx context.Context, site Site, history *powerwall.HistoryData) error {
+	attrs := otlpSiteAttributes(site)
+
+	byName := map[string]*otlpMetric{}
+	var order []string
+	for _, point := range history.TimeSeries {
+		ts := strconv.FormatInt(point.Timestamp.UnixNano(), 10)
+		for _, f := range timePointFields(point) {
+			m, ok := byName[f.name]
+			if !ok {
+				m = &otlpMetric{Name: "powerwall.history." + f.name, Unit: f.unit}
+				byName[f.name] = m
+				order = append(order, f.name)
+			}
+			m.Gauge.DataPoints = append(m.Gauge.DataPoints, otlpDataPoint{
+				Attributes:   attrs,
+				TimeUnixNano: ts,
+				AsDouble:     f.value,
+			})
+		}
+	}
+	if len(order) == 0 {
+		return nil
+	}
+
+	metrics := make([]otlpMetric, 0, len(order))
+	for _, name := range order {
+		metrics = append(metrics, *byName[name])
+	}
+	return s.export(ctx, metrics)
+}
+
+func (s *OTLPSink) export(ctx context.Context, metrics []otlpMetric) error {
+	body, err := json.Marshal(otlpRequest{
+		ResourceMetrics: []otlpResourceMetrics{{
+			Resource: otlpResource{Attributes: []otlpAttribute{
+				{"service.name", otlpAnyValue{s.serviceName}},
+			}},
+			ScopeMetrics: []otlpScopeMetrics{{
+				Scope:   otlpScope{Name: "github.com/blampe/powerwall/metrics"},
+				Metrics: metrics,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range s.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("OTLP export returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+func otlpSiteAttributes(site Site) []otlpAttribute {
+	return []otlpAttribute{
+		{"site_id", otlpAnyValue{strconv.FormatInt(site.ID, 10)}},
+		{"site_name", otlpAnyValue{site.Name}},
+	}
+}
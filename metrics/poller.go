@@ -0,0 +1,259 @@
+// Package metrics exports Powerwall data to monitoring systems.
+//
+// A Poller fetches live_status and site_info for one or more energy sites,
+// paced to stay within the client's rate limit, and hands each snapshot to a
+// set of Sinks.  It can optionally fetch energy history periodically as well.
+// Sinks are provided for Prometheus (Exporter), InfluxDB line protocol
+// (InfluxSink) and OTLP metrics over HTTP (OTLPSink):
+//
+//	exporter := metrics.NewExporter()
+//	client := powerwall.NewClient(clientID, accessToken, refreshToken,
+//		powerwall.WithRequestObserver(exporter.ObserveRequest))
+//	http.Handle("/metrics", exporter.Handler())
+//
+//	poller := metrics.NewPoller([]metrics.Sink{exporter, metrics.NewInfluxSink(influxURL)},
+//		metrics.WithSites(siteID))
+//	go poller.Run(ctx, client)
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/blampe/powerwall"
+)
+
+const (
+	// DefaultInterval is the default polling interval for each site.
+	DefaultInterval = time.Minute
+
+	// requestsPerPoll is the number of Fleet API requests made for each site
+	// on every poll (live_status + site_info).
+	requestsPerPoll = 2
+)
+
+// Site identifies an energy site being exported.
+type Site struct {
+	ID   int64
+	Name string
+}
+
+// Snapshot is the state of a single site collected by one poll.
+type Snapshot struct {
+	Site          Site
+	LiveStatus    *powerwall.LiveStatus
+	BackupReserve int
+}
+
+// Sink is the interface implemented by metric destinations.
+type Sink interface {
+	// WriteSnapshot records a polled site snapshot.
+	WriteSnapshot(ctx context.Context, snapshot Snapshot) error
+
+	// WriteHistory records the points of a history query.  Points may be
+	// written more than once, so sinks should treat (site, timestamp) as a
+	// key where they can.
+	WriteHistory(ctx context.Context, site Site, history *powerwall.HistoryData) error
+}
+
+// Poller polls energy sites and writes the results to its sinks.
+type Poller struct {
+	sinks           []Sink
+	siteIDs         []int64
+	interval        time.Duration
+	historyInterval time.Duration
+	errHandler      func(error)
+}
+
+// NewPoller creates a Poller which writes to the given sinks.
+func NewPoller(sinks []Sink, options ...func(p *Poller)) *Poller {
+	p := &Poller{
+		sinks:      sinks,
+		interval:   DefaultInterval,
+		errHandler: func(error) {},
+	}
+
+	// Apply options
+	for _, option := range options {
+		if option != nil {
+			option(p)
+		}
+	}
+
+	return p
+}
+
+// WithSites restricts the poller to the given energy site IDs.  By default
+// all energy sites on the account are polled.
+func WithSites(siteIDs ...int64) func(p *Poller) {
+	return func(p *Poller) {
+		p.siteIDs = siteIDs
+	}
+}
+
+// WithInterval sets the polling interval.  Intervals which would exceed the
+// client's rate limit are raised to the smallest interval that fits.
+func WithInterval(interval time.Duration) func(p *Poller) {
+	return func(p *Poller) {
+		p.interval = interval
+	}
+}
+
+// WithHistoryInterval enables periodic fetching of today's energy history,
+// which is written to every sink's WriteHistory.  History is disabled by
+// default.
+func WithHistoryInterval(interval time.Duration) func(p *Poller) {
+	return func(p *Poller) {
+		p.historyInterval = interval
+	}
+}
+
+// WithErrorHandler receives failed polls and sink writes, prefixed with the
+// site ID.
+func WithErrorHandler(f func(error)) func(p *Poller) {
+	return func(p *Poller) {
+		p.errHandler = f
+	}
+}
+
+// Run polls every site until ctx is cancelled.  The poller selects each site
+// in turn, so the client must not be used by anything else while Run is active.
+func (p *Poller) Run(ctx context.Context, client *powerwall.Client) error {
+	sites, err := ResolveSitesContext(ctx, client, p.siteIDs)
+	if err != nil {
+		return err
+	}
+
+	interval := PollInterval(client, p.interval, len(sites)*requestsPerPoll)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastHistory time.Time
+	for {
+		fetchHistory := p.historyInterval > 0 && time.Since(lastHistory) >= p.historyInterval
+		for _, site := range sites {
+			if err := p.poll(ctx, client, site); err != nil {
+				p.errHandler(fmt.Errorf("site %d: %w", site.ID, err))
+			}
+			if fetchHistory {
+				if err := p.pollHistory(ctx, client, site); err != nil {
+					p.errHandler(fmt.Errorf("site %d history: %w", site.ID, err))
+				}
+			}
+		}
+		if fetchHistory {
+			lastHistory = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll fetches the current state of a single site and writes it to all sinks.
+func (p *Poller) poll(ctx context.Context, client *powerwall.Client, site Site) error {
+	if err := client.SelectEnergySite(site.ID); err != nil {
+		return err
+	}
+
+	status, err := client.GetLiveStatusContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	reserve, err := client.GetBackupReserveContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	snapshot := Snapshot{
+		Site:          site,
+		LiveStatus:    status,
+		BackupReserve: reserve,
+	}
+	for _, sink := range p.sinks {
+		if err := sink.WriteSnapshot(ctx, snapshot); err != nil {
+			p.errHandler(fmt.Errorf("site %d: writing snapshot to %T: %w", site.ID, sink, err))
+		}
+	}
+	return nil
+}
+
+// pollHistory fetches today's energy history for a single site and writes it
+// to all sinks.
+func (p *Poller) pollHistory(ctx context.Context, client *powerwall.Client, site Site) error {
+	if err := client.SelectEnergySite(site.ID); err != nil {
+		return err
+	}
+
+	today := time.Now().Format("2006-01-02")
+	history, err := client.GetEnergyHistoryContext(ctx, today, today, "day")
+	if err != nil {
+		return err
+	}
+
+	for _, sink := range p.sinks {
+		if err := sink.WriteHistory(ctx, site, history); err != nil {
+			p.errHandler(fmt.Errorf("site %d: writing history to %T: %w", site.ID, sink, err))
+		}
+	}
+	return nil
+}
+
+// ResolveSites looks up the names of the given energy site IDs.  If no IDs are
+// given, all energy sites on the account are returned.
+func ResolveSites(client *powerwall.Client, siteIDs []int64) ([]Site, error) {
+	return ResolveSitesContext(context.Background(), client, siteIDs)
+}
+
+// ResolveSitesContext is like ResolveSites but uses ctx for cancellation and
+// tracing.
+func ResolveSitesContext(ctx context.Context, client *powerwall.Client, siteIDs []int64) ([]Site, error) {
+	products, err := client.GetEnergyProductsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[int64]string, len(products))
+	for _, product := range products {
+		names[product.EnergyProductID] = product.SiteName
+	}
+
+	if len(siteIDs) == 0 {
+		for _, product := range products {
+			siteIDs = append(siteIDs, product.EnergyProductID)
+		}
+	}
+	if len(siteIDs) == 0 {
+		return nil, fmt.Errorf("no energy sites found")
+	}
+
+	sites := make([]Site, 0, len(siteIDs))
+	for _, id := range siteIDs {
+		name, ok := names[id]
+		if !ok {
+			return nil, fmt.Errorf("energy site %d not found on this account", id)
+		}
+		sites = append(sites, Site{ID: id, Name: name})
+	}
+	return sites, nil
+}
+
+// PollInterval returns the requested interval, raised if necessary so that
+// making requestsPerInterval requests every interval stays within the
+// client's rate limit.
+func PollInterval(client *powerwall.Client, requested time.Duration, requestsPerInterval int) time.Duration {
+	rpm := client.GetRateLimit()
+	if rpm <= 0 {
+		return requested
+	}
+	minimum := time.Duration(requestsPerInterval) * time.Minute / time.Duration(rpm)
+	if requested < minimum {
+		return minimum
+	}
+	return requested
+}
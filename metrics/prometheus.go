@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/blampe/powerwall"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var siteLabels = []string{"site_id", "site_name"}
+
+// Exporter is a Sink which exposes site snapshots as Prometheus gauges.  It
+// also counts Fleet API requests and errors when registered as the client's
+// request observer.
+type Exporter struct {
+	registry *prometheus.Registry
+
+	solarPower      *prometheus.GaugeVec
+	batteryPower    *prometheus.GaugeVec
+	gridPower       *prometheus.GaugeVec
+	loadPower       *prometheus.GaugeVec
+	soe             *prometheus.GaugeVec
+	energyLeft      *prometheus.GaugeVec
+	totalPackEnergy *prometheus.GaugeVec
+	gridStatus      *prometheus.GaugeVec
+	stormMode       *prometheus.GaugeVec
+	backupReserve   *prometheus.GaugeVec
+	lastUpdate      *prometheus.GaugeVec
+
+	apiRequests *prometheus.CounterVec
+	apiErrors   *prometheus.CounterVec
+}
+
+// NewExporter creates an Exporter with its own Prometheus registry.
+func NewExporter() *Exporter {
+	gauge := func(name, help string, extraLabels ...string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "powerwall",
+			Name:      name,
+			Help:      help,
+		}, append(append([]string{}, siteLabels...), extraLabels...))
+	}
+
+	e := &Exporter{
+		registry: prometheus.NewRegistry(),
+
+		solarPower:      gauge("solar_power_watts", "Current solar generation in watts."),
+		batteryPower:    gauge("battery_power_watts", "Current battery power in watts (positive when discharging)."),
+		gridPower:       gauge("grid_power_watts", "Current grid power in watts (positive when importing)."),
+		loadPower:       gauge("load_power_watts", "Current home consumption in watts."),
+		soe:             gauge("soe_percent", "Battery state of energy in percent."),
+		energyLeft:      gauge("energy_left_watt_hours", "Energy remaining in the battery in watt-hours."),
+		totalPackEnergy: gauge("total_pack_energy_watt_hours", "Total usable battery capacity in watt-hours."),
+		gridStatus:      gauge("grid_status", "Grid connection status reported by the gateway (1 for the current status).", "status"),
+		stormMode:       gauge("storm_mode_active", "Whether Storm Watch is currently active (1) or not (0)."),
+		backupReserve:   gauge("backup_reserve_percent", "Configured backup reserve in percent."),
+		lastUpdate:      gauge("live_status_timestamp_seconds", "Timestamp of the most recent live_status sample."),
+
+		apiRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "powerwall",
+			Name:      "api_requests_total",
+			Help:      "Fleet API requests by endpoint, method and status code.",
+		}, []string{"endpoint", "method", "status_code"}),
+		apiErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "powerwall",
+			Name:      "api_errors_total",
+			Help:      "Failed Fleet API requests by endpoint and status code.",
+		}, []string{"endpoint", "status_code"}),
+	}
+
+	e.registry.MustRegister(
+		e.solarPower, e.batteryPower, e.gridPower, e.loadPower,
+		e.soe, e.energyLeft, e.totalPackEnergy,
+		e.gridStatus, e.stormMode, e.backupReserve, e.lastUpdate,
+		e.apiRequests, e.apiErrors,
+	)
+
+	return e
+}
+
+// Handler returns an http.Handler serving the exporter's metrics.
+func (e *Exporter) Handler() http.Handler {
+	return promhttp.HandlerFor(e.registry, promhttp.HandlerOpts{})
+}
+
+// Registry returns the Prometheus registry holding the exporter's metrics.
+func (e *Exporter) Registry() *prometheus.Registry {
+	return e.registry
+}
+
+// ObserveRequest counts a completed Fleet API request.  It is meant to be
+// registered with powerwall.WithRequestObserver.
+func (e *Exporter) ObserveRequest(info powerwall.RequestInfo) {
+	statusCode := strconv.Itoa(info.StatusCode)
+	e.apiRequests.WithLabelValues(info.Endpoint, info.Method, statusCode).Inc()
+	if info.Err != nil || info.StatusCode >= 400 {
+		e.apiErrors.WithLabelValues(info.Endpoint, statusCode).Inc()
+	}
+}
+
+// WriteSnapshot updates the gauges of the snapshot's site.
+func (e *Exporter) WriteSnapshot(ctx context.Context, snapshot Snapshot) error {
+	labels := snapshot.Site.labels()
+	status := snapshot.LiveStatus
+
+	setOptional := func(g *prometheus.GaugeVec, v *float64) {
+		if v != nil {
+			g.WithLabelValues(labels...).Set(*v)
+		}
+	}
+	setOptional(e.solarPower, status.SolarPower)
+	setOptional(e.batteryPower, status.BatteryPower)
+	setOptional(e.gridPower, status.GridPower)
+	setOptional(e.loadPower, status.LoadPower)
+	setOptional(e.soe, status.PercentageCharged)
+	setOptional(e.energyLeft, status.EnergyLeft)
+	setOptional(e.totalPackEnergy, status.TotalPackEnergy)
+
+	e.gridStatus.DeletePartialMatch(prometheus.Labels{"site_id": labels[0]})
+	e.gridStatus.WithLabelValues(append(labels, status.GridStatus)...).Set(1)
+
+	e.stormMode.WithLabelValues(labels...).Set(boolToFloat(status.StormModeActive))
+	e.backupReserve.WithLabelValues(labels...).Set(float64(snapshot.BackupReserve))
+	e.lastUpdate.WithLabelValues(labels...).Set(float64(status.Timestamp.Unix()))
+	return nil
+}
+
+// WriteHistory does nothing: Prometheus scrapes current values only.
+func (e *Exporter) WriteHistory(ctx context.Context, site Site, history *powerwall.HistoryData) error {
+	return nil
+}
+
+func (s Site) labels() []string {
+	return []string{strconv.FormatInt(s.ID, 10), s.Name}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
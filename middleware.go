@@ -0,0 +1,96 @@
+// HTTP request middleware, User-Agent and per-request timeouts
+//
+//	WithMiddleware(mw...) - Wrap every Fleet API request
+//	WithUserAgent(ua) - Set the User-Agent header
+//	WithRequestTimeouts(timeouts) - Separate timeouts for live, history and command calls
+
+package powerwall
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultUserAgent is the User-Agent header sent unless WithUserAgent
+// overrides it.
+const DefaultUserAgent = "go-powerwall/v2.0"
+
+// RoundTripFunc sends a single HTTP request and returns its response.
+type RoundTripFunc func(req *http.Request) (*http.Response, error)
+
+// Middleware wraps a RoundTripFunc, for example to add headers, log or
+// record requests and responses, or inject faults.  A middleware which
+// doesn't call next must return a response or an error itself.
+type Middleware func(next RoundTripFunc) RoundTripFunc
+
+// WithMiddleware adds middleware around every Fleet API request, inside the
+// client's authentication and rate limiting and outside the http.Client.
+// The first middleware given is the outermost, and middleware from repeated
+// calls is added inside that already registered.
+func WithMiddleware(mw ...Middleware) func(c *Client) {
+	return func(c *Client) {
+		c.middleware = append(c.middleware, mw...)
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request.
+func WithUserAgent(ua string) func(c *Client) {
+	return func(c *Client) {
+		c.userAgent = ua
+	}
+}
+
+// RequestTimeouts are the timeouts of each kind of Fleet API request.  A
+// zero timeout leaves the request bounded only by the http.Client's Timeout,
+// which also caps the timeouts set here (30s by default, see WithHttpClient).
+type RequestTimeouts struct {
+	Live    time.Duration // live_status, site_info, products and other reads
+	History time.Duration // calendar_history and telemetry_history
+	Command time.Duration // POST requests
+}
+
+// WithRequestTimeouts sets per-request timeouts by kind of request, so that
+// slow history queries can be given longer than live calls.
+func WithRequestTimeouts(timeouts RequestTimeouts) func(c *Client) {
+	return func(c *Client) {
+		c.requestTimeouts = timeouts
+	}
+}
+
+// requestTimeout returns the configured timeout for a request.
+func (c *Client) requestTimeout(method, endpoint string) time.Duration {
+	switch {
+	case method == http.MethodPost:
+		return c.requestTimeouts.Command
+	case strings.Contains(endpoint, "_history"):
+		return c.requestTimeouts.History
+	default:
+		return c.requestTimeouts.Live
+	}
+}
+
+// send runs a request through the middleware chain and the http.Client,
+// applying the request's timeout.  The returned cancel function must be
+// called once the response body has been read.
+func (c *Client) send(req *http.Request, endpoint string) (*http.Response, context.CancelFunc, error) {
+	cancel := context.CancelFunc(func() {})
+	if timeout := c.requestTimeout(req.Method, endpoint); timeout > 0 {
+		var ctx context.Context
+		ctx, cancel = context.WithTimeout(req.Context(), timeout)
+		req = req.WithContext(ctx)
+	}
+
+	next := RoundTripFunc(c.httpClient.Do)
+	for i := len(c.middleware) - 1; i >= 0; i-- {
+		next = c.middleware[i](next)
+	}
+
+	resp, err := next(req)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return resp, cancel, nil
+}
@@ -0,0 +1,317 @@
+// Package mqttbridge publishes Powerwall data to MQTT for Home Assistant.
+//
+// A Bridge polls live_status for the client's selected energy site and
+// publishes every scalar field to its own retained topic under
+// "<prefix>/<site_id>/", along with the backup reserve, whether Storm Watch is
+// enabled and the operation mode.  It announces sensors, a backup reserve
+// number, a Storm Watch switch and an operation mode select through Home
+// Assistant MQTT discovery, and listens on the matching "/set" command topics:
+//
+//	<prefix>/<site_id>/backup_reserve_percent/set  - SetBackupReserve (0-100)
+//	<prefix>/<site_id>/storm_mode_enabled/set      - SetStormMode (true/false)
+//	<prefix>/<site_id>/operation_mode/set          - SetOperationMode
+//
+// The Storm Watch switch is whether Storm Watch is enabled.  Whether a storm
+// is being prepared for right now is live_status storm_mode_active, which is
+// announced as a read-only binary sensor.
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blampe/powerwall"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	// DefaultTopicPrefix is the default prefix for state and command topics.
+	DefaultTopicPrefix = "powerwall"
+
+	// DefaultDiscoveryPrefix is Home Assistant's default discovery prefix.
+	DefaultDiscoveryPrefix = "homeassistant"
+
+	// DefaultInterval is the default live_status polling interval.
+	DefaultInterval = time.Minute
+
+	// publishTimeout bounds how long we wait for the broker to acknowledge a
+	// publish or subscribe.
+	publishTimeout = 10 * time.Second
+)
+
+// Bridge connects a single energy site to an MQTT broker.
+type Bridge struct {
+	client          *powerwall.Client
+	mqtt            mqtt.Client
+	siteID          int64
+	siteName        string
+	topicPrefix     string
+	discoveryPrefix string
+	interval        time.Duration
+	errHandler      func(error)
+
+	// Commands received from MQTT are handed to the Run loop so that they
+	// never use the Fleet API client concurrently with polling.
+	commands chan command
+}
+
+// command is a request received on one of the command topics.
+type command struct {
+	name    string
+	payload string
+}
+
+// New creates a Bridge for the client's currently selected energy site.  The
+// MQTT client must already be connected.  If the broker connection should
+// report the bridge as offline when it drops, configure the MQTT client with
+// a will message on AvailabilityTopic().
+func New(client *powerwall.Client, mqttClient mqtt.Client, options ...func(b *Bridge)) *Bridge {
+	b := &Bridge{
+		client:          client,
+		mqtt:            mqttClient,
+		siteID:          client.GetSelectedEnergySite(),
+		topicPrefix:     DefaultTopicPrefix,
+		discoveryPrefix: DefaultDiscoveryPrefix,
+		interval:        DefaultInterval,
+		errHandler:      func(error) {},
+		commands:        make(chan command, 8),
+	}
+
+	// Apply options
+	for _, option := range options {
+		if option != nil {
+			option(b)
+		}
+	}
+
+	if b.siteName == "" {
+		b.siteName = fmt.Sprintf("Powerwall %d", b.siteID)
+	}
+
+	return b
+}
+
+// WithTopicPrefix sets the prefix for state and command topics
+func WithTopicPrefix(prefix string) func(b *Bridge) {
+	return func(b *Bridge) {
+		b.topicPrefix = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// WithDiscoveryPrefix sets the Home Assistant discovery prefix
+func WithDiscoveryPrefix(prefix string) func(b *Bridge) {
+	return func(b *Bridge) {
+		b.discoveryPrefix = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// WithSiteName sets the device name shown in Home Assistant
+func WithSiteName(name string) func(b *Bridge) {
+	return func(b *Bridge) {
+		b.siteName = name
+	}
+}
+
+// WithInterval sets the live_status polling interval
+func WithInterval(interval time.Duration) func(b *Bridge) {
+	return func(b *Bridge) {
+		b.interval = interval
+	}
+}
+
+// WithErrorHandler registers a callback for failed polls, rejected or
+// malformed commands and commands dropped because too many were pending.
+func WithErrorHandler(f func(error)) func(b *Bridge) {
+	return func(b *Bridge) {
+		b.errHandler = f
+	}
+}
+
+// AvailabilityTopic returns the topic on which the bridge publishes "online"
+// and "offline".
+func (b *Bridge) AvailabilityTopic() string {
+	return AvailabilityTopic(b.topicPrefix, b.siteID)
+}
+
+// AvailabilityTopic returns the availability topic used by a bridge with the
+// given topic prefix and energy site.  This is useful for configuring the MQTT
+// client's will message before the bridge is created.
+func AvailabilityTopic(topicPrefix string, siteID int64) string {
+	return fmt.Sprintf("%s/%d/availability", strings.TrimSuffix(topicPrefix, "/"), siteID)
+}
+
+// Run publishes discovery configs, subscribes to the command topics and then
+// polls until ctx is cancelled.
+func (b *Bridge) Run(ctx context.Context) error {
+	if err := b.publishDiscovery(); err != nil {
+		return err
+	}
+	if err := b.subscribe(); err != nil {
+		return err
+	}
+	defer b.unsubscribe()
+
+	if err := b.publish(b.AvailabilityTopic(), "online"); err != nil {
+		return err
+	}
+	defer b.publish(b.AvailabilityTopic(), "offline")
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := b.PollContext(ctx); err != nil {
+			b.errHandler(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case cmd := <-b.commands:
+			if err := b.handleCommand(ctx, cmd); err != nil {
+				b.errHandler(err)
+			}
+		}
+	}
+}
+
+// Poll fetches the site's current state once and publishes it.
+func (b *Bridge) Poll() error {
+	return b.PollContext(context.Background())
+}
+
+// PollContext is like Poll but uses ctx for cancellation and tracing.
+func (b *Bridge) PollContext(ctx context.Context) error {
+	status, err := b.client.GetLiveStatusContext(ctx)
+	if err != nil {
+		return err
+	}
+	if err := b.publishLiveStatus(status); err != nil {
+		return err
+	}
+
+	reserve, err := b.client.GetBackupReserveContext(ctx)
+	if err != nil {
+		return err
+	}
+	if err := b.publish(b.topic("backup_reserve_percent"), strconv.Itoa(reserve)); err != nil {
+		return err
+	}
+
+	stormMode, err := b.client.GetStormModeContext(ctx)
+	if err != nil {
+		return err
+	}
+	if err := b.publish(b.topic("storm_mode_enabled"), strconv.FormatBool(stormMode)); err != nil {
+		return err
+	}
+
+	mode, err := b.client.GetOperationModeContext(ctx)
+	if err != nil {
+		return err
+	}
+	return b.publish(b.topic("operation_mode"), mode)
+}
+
+// publishLiveStatus publishes every scalar live_status field to a topic named
+// after its JSON key.  Fields the gateway didn't report are skipped.
+func (b *Bridge) publishLiveStatus(status *powerwall.LiveStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	for name, value := range fields {
+		var payload string
+		switch v := value.(type) {
+		case string:
+			payload = v
+		case float64:
+			payload = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			payload = strconv.FormatBool(v)
+		default:
+			// nil or nested meter data
+			continue
+		}
+		if err := b.publish(b.topic(name), payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bridge) subscribe() error {
+	for _, name := range []string{"backup_reserve_percent", "storm_mode_enabled", "operation_mode"} {
+		token := b.mqtt.Subscribe(b.topic(name, "set"), 1, func(_ mqtt.Client, msg mqtt.Message) {
+			select {
+			case b.commands <- command{name: name, payload: strings.TrimSpace(string(msg.Payload()))}:
+			default:
+				b.errHandler(fmt.Errorf("dropped %s command %q: too many pending commands", name, msg.Payload()))
+			}
+		})
+		if err := waitToken(token); err != nil {
+			return fmt.Errorf("subscribing to %s: %w", b.topic(name, "set"), err)
+		}
+	}
+	return nil
+}
+
+func (b *Bridge) unsubscribe() {
+	b.mqtt.Unsubscribe(
+		b.topic("backup_reserve_percent", "set"),
+		b.topic("storm_mode_enabled", "set"),
+		b.topic("operation_mode", "set"),
+	)
+}
+
+// handleCommand applies a command received over MQTT.  The Run loop polls
+// immediately afterwards, which publishes the new state.
+func (b *Bridge) handleCommand(ctx context.Context, cmd command) error {
+	var err error
+	switch cmd.name {
+	case "backup_reserve_percent":
+		var percent float64
+		percent, err = strconv.ParseFloat(cmd.payload, 64)
+		if err != nil {
+			return fmt.Errorf("invalid backup reserve command %q: %w", cmd.payload, err)
+		}
+		err = b.client.SetBackupReserveContext(ctx, int(percent))
+	case "storm_mode_enabled":
+		var enabled bool
+		enabled, err = strconv.ParseBool(cmd.payload)
+		if err != nil {
+			return fmt.Errorf("invalid storm mode command %q: %w", cmd.payload, err)
+		}
+		err = b.client.SetStormModeContext(ctx, enabled)
+	case "operation_mode":
+		err = b.client.SetOperationModeContext(ctx, cmd.payload)
+	}
+	return err
+}
+
+// topic joins the bridge's topic prefix, site ID and the given parts.
+func (b *Bridge) topic(parts ...string) string {
+	return strings.Join(append([]string{b.topicPrefix, strconv.FormatInt(b.siteID, 10)}, parts...), "/")
+}
+
+// publish sends a retained QoS 1 message and waits for it to be acknowledged.
+func (b *Bridge) publish(topic, payload string) error {
+	return waitToken(b.mqtt.Publish(topic, 1, true, payload))
+}
+
+func waitToken(token mqtt.Token) error {
+	if !token.WaitTimeout(publishTimeout) {
+		return fmt.Errorf("timed out waiting for MQTT broker")
+	}
+	return token.Error()
+}
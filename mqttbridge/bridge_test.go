@@ -0,0 +1,257 @@
+package mqttbridge_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blampe/powerwall"
+	"github.com/blampe/powerwall/mqttbridge"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	server "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+const siteID = 1000000000000001
+
+// fleetAPI serves a single energy site and records the commands posted to it.
+type fleetAPI struct {
+	commands chan string
+}
+
+func (f *fleetAPI) RoundTrip(req *http.Request) (*http.Response, error) {
+	sitePath := fmt.Sprintf("/api/1/energy_sites/%d/", siteID)
+
+	var body string
+	switch {
+	case req.URL.Path == "/oauth2/v3/token":
+		body = `{"access_token":"access","refresh_token":"refresh","expires_in":28800}`
+	case req.URL.Path == sitePath+"live_status":
+		body = `{"response":{"percentage_charged":81.5,"solar_power":3120,"grid_status":"Active","storm_mode_active":false}}`
+	case req.URL.Path == sitePath+"site_info":
+		body = `{"response":{"backup_reserve_percent":20,"default_real_mode":"self_consumption","user_settings":{"storm_mode_enabled":true}}}`
+	case req.Method == http.MethodPost && strings.HasPrefix(req.URL.Path, sitePath):
+		payload, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		f.commands <- strings.TrimPrefix(req.URL.Path, sitePath) + " " + string(payload)
+		body = `{"response":{"code":201,"message":"Updated"}}`
+	default:
+		return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader("{}")), Request: req}, nil
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// retained collects the messages published under the subscribed topics.
+type retained struct {
+	mu       sync.Mutex
+	messages map[string]string
+}
+
+func (r *retained) handle(_ mqtt.Client, msg mqtt.Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages[msg.Topic()] = string(msg.Payload())
+}
+
+// wait returns the payload of topic once it has been published.
+func (r *retained) wait(t *testing.T, topic string) string {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		payload, ok := r.messages[topic]
+		r.mu.Unlock()
+		if ok {
+			return payload
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("nothing published on %s", topic)
+	return ""
+}
+
+func startBroker(t *testing.T) string {
+	t.Helper()
+	broker := server.New(&server.Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	if err := broker.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.AddListener(listeners.NewNet("test", ln)); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { broker.Close() })
+	return "tcp://" + ln.Addr().String()
+}
+
+func connect(t *testing.T, url, clientID string) mqtt.Client {
+	t.Helper()
+	client := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(url).SetClientID(clientID))
+	if token := client.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connecting to broker: %v", token.Error())
+	}
+	t.Cleanup(func() { client.Disconnect(100) })
+	return client
+}
+
+func TestBridge(t *testing.T) {
+	url := startBroker(t)
+
+	observer := connect(t, url, "observer")
+	messages := &retained{messages: map[string]string{}}
+	if token := observer.Subscribe("#", 1, messages.handle); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("subscribing: %v", token.Error())
+	}
+
+	api := &fleetAPI{commands: make(chan string, 4)}
+	client := powerwall.NewClient("client-id", "", "refresh",
+		powerwall.WithHttpClient(&http.Client{Transport: api}),
+		// Every site_info read after the first comes from the cache, so the
+		// test isn't slowed down by the client's rate limit
+		powerwall.WithCache(powerwall.CacheTTLs{SiteInfo: time.Hour, LiveStatus: time.Hour}))
+	client.SelectEnergySite(siteID)
+
+	errs := make(chan error, 4)
+	bridge := mqttbridge.New(client, connect(t, url, "bridge"),
+		mqttbridge.WithSiteName("Main St"),
+		mqttbridge.WithInterval(time.Hour),
+		mqttbridge.WithErrorHandler(func(err error) { errs <- err }))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- bridge.Run(ctx) }()
+
+	prefix := fmt.Sprintf("powerwall/%d/", siteID)
+	nodeID := fmt.Sprintf("powerwall_%d", siteID)
+
+	t.Run("discovery", func(t *testing.T) {
+		var config map[string]interface{}
+		decode := func(component, field string) {
+			t.Helper()
+			config = nil
+			topic := fmt.Sprintf("homeassistant/%s/%s/%s/config", component, nodeID, field)
+			if err := json.Unmarshal([]byte(messages.wait(t, topic)), &config); err != nil {
+				t.Fatalf("%s: %v", topic, err)
+			}
+			if config["state_topic"] != prefix+field {
+				t.Errorf("%s state_topic = %v, want %s", topic, config["state_topic"], prefix+field)
+			}
+			if config["availability_topic"] != prefix+"availability" {
+				t.Errorf("%s availability_topic = %v", topic, config["availability_topic"])
+			}
+		}
+
+		decode("switch", "storm_mode_enabled")
+		if config["command_topic"] != prefix+"storm_mode_enabled/set" {
+			t.Errorf("Storm Watch switch command_topic = %v", config["command_topic"])
+		}
+		if device, _ := config["device"].(map[string]interface{}); device["name"] != "Main St" {
+			t.Errorf("device = %v, want name Main St", config["device"])
+		}
+
+		decode("binary_sensor", "storm_mode_active")
+		if _, ok := config["command_topic"]; ok {
+			t.Errorf("Storm Watch active binary sensor has a command_topic: %v", config["command_topic"])
+		}
+
+		decode("sensor", "percentage_charged")
+		if config["unit_of_measurement"] != "%" || config["command_topic"] != nil {
+			t.Errorf("battery charge sensor config = %v", config)
+		}
+
+		decode("number", "backup_reserve_percent")
+		if config["command_topic"] != prefix+"backup_reserve_percent/set" || config["min"] != 0.0 || config["max"] != 100.0 {
+			t.Errorf("backup reserve number config = %v", config)
+		}
+
+		decode("select", "operation_mode")
+		if config["command_topic"] != prefix+"operation_mode/set" {
+			t.Errorf("operation mode select command_topic = %v", config["command_topic"])
+		}
+	})
+
+	t.Run("state", func(t *testing.T) {
+		for topic, want := range map[string]string{
+			"availability":           "online",
+			"percentage_charged":     "81.5",
+			"solar_power":            "3120",
+			"grid_status":            "Active",
+			"storm_mode_active":      "false",
+			"storm_mode_enabled":     "true",
+			"backup_reserve_percent": "20",
+			"operation_mode":         "self_consumption",
+		} {
+			if got := messages.wait(t, prefix+topic); got != want {
+				t.Errorf("%s = %q, want %q", topic, got, want)
+			}
+		}
+	})
+
+	t.Run("commands", func(t *testing.T) {
+		publish := func(topic, payload string) {
+			t.Helper()
+			if token := observer.Publish(prefix+topic, 1, false, payload); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+				t.Fatalf("publishing %s: %v", topic, token.Error())
+			}
+		}
+
+		publish("storm_mode_enabled/set", "maybe")
+		select {
+		case err := <-errs:
+			if !strings.Contains(err.Error(), "invalid storm mode command") {
+				t.Errorf("error = %v, want an invalid storm mode command", err)
+			}
+		case cmd := <-api.commands:
+			t.Fatalf("invalid command was sent: %s", cmd)
+		case <-time.After(10 * time.Second):
+			t.Fatal("invalid command wasn't reported")
+		}
+
+		publish("storm_mode_enabled/set", "false")
+		select {
+		case cmd := <-api.commands:
+			if want := `storm_mode {"enabled":false}`; cmd != want {
+				t.Errorf("command = %s, want %s", cmd, want)
+			}
+		case err := <-errs:
+			t.Fatalf("command failed: %v", err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("command wasn't sent")
+		}
+	})
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("Run = %v, want context.Canceled", err)
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatal("Run didn't return after cancellation")
+	}
+	if got := messages.wait(t, prefix+"availability"); got != "offline" {
+		t.Errorf("availability after Run = %q, want offline", got)
+	}
+}
@@ -0,0 +1,131 @@
+package mqttbridge
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/blampe/powerwall"
+)
+
+// discoveryDevice groups all entities of a site under one Home Assistant
+// device.
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+// discoveryConfig is the payload of a Home Assistant MQTT discovery message.
+// Only the fields used by the entities below are included.
+type discoveryConfig struct {
+	Name              string          `json:"name"`
+	UniqueID          string          `json:"unique_id"`
+	StateTopic        string          `json:"state_topic"`
+	CommandTopic      string          `json:"command_topic,omitempty"`
+	AvailabilityTopic string          `json:"availability_topic"`
+	DeviceClass       string          `json:"device_class,omitempty"`
+	StateClass        string          `json:"state_class,omitempty"`
+	UnitOfMeasurement string          `json:"unit_of_measurement,omitempty"`
+	PayloadOn         string          `json:"payload_on,omitempty"`
+	PayloadOff        string          `json:"payload_off,omitempty"`
+	Options           []string        `json:"options,omitempty"`
+	Min               *float64        `json:"min,omitempty"`
+	Max               *float64        `json:"max,omitempty"`
+	Step              *float64        `json:"step,omitempty"`
+	Device            discoveryDevice `json:"device"`
+}
+
+// discoveryEntity describes one entity announced to Home Assistant.
+type discoveryEntity struct {
+	component string // "sensor", "binary_sensor", "switch", "number", "select"
+	field     string // state topic suffix, also used for the unique ID
+	config    discoveryConfig
+}
+
+func (b *Bridge) discoveryEntities() []discoveryEntity {
+	sensor := func(field, name, deviceClass, unit string) discoveryEntity {
+		stateClass := ""
+		if unit != "" {
+			stateClass = "measurement"
+		}
+		return discoveryEntity{"sensor", field, discoveryConfig{
+			Name:              name,
+			DeviceClass:       deviceClass,
+			StateClass:        stateClass,
+			UnitOfMeasurement: unit,
+		}}
+	}
+	float := func(f float64) *float64 { return &f }
+
+	return []discoveryEntity{
+		sensor("solar_power", "Solar power", "power", "W"),
+		sensor("battery_power", "Battery power", "power", "W"),
+		sensor("grid_power", "Grid power", "power", "W"),
+		sensor("load_power", "Home power", "power", "W"),
+		sensor("percentage_charged", "Battery charge", "battery", "%"),
+		sensor("energy_left", "Battery energy", "energy_storage", "Wh"),
+		sensor("total_pack_energy", "Battery capacity", "energy_storage", "Wh"),
+		sensor("grid_status", "Grid status", "", ""),
+		sensor("island_status", "Island status", "", ""),
+		{"binary_sensor", "storm_mode_active", discoveryConfig{
+			Name:       "Storm Watch active",
+			PayloadOn:  "true",
+			PayloadOff: "false",
+		}},
+		{"number", "backup_reserve_percent", discoveryConfig{
+			Name:              "Backup reserve",
+			UnitOfMeasurement: "%",
+			Min:               float(0),
+			Max:               float(100),
+			Step:              float(1),
+		}},
+		{"switch", "storm_mode_enabled", discoveryConfig{
+			Name:       "Storm Watch",
+			PayloadOn:  "true",
+			PayloadOff: "false",
+		}},
+		{"select", "operation_mode", discoveryConfig{
+			Name: "Operation mode",
+			Options: []string{
+				powerwall.OperationModeSelfConsumption,
+				powerwall.OperationModeAutonomous,
+				powerwall.OperationModeBackup,
+			},
+		}},
+	}
+}
+
+// publishDiscovery announces all entities through Home Assistant MQTT
+// discovery.
+func (b *Bridge) publishDiscovery() error {
+	nodeID := fmt.Sprintf("powerwall_%d", b.siteID)
+	device := discoveryDevice{
+		Identifiers:  []string{nodeID},
+		Name:         b.siteName,
+		Manufacturer: "Tesla",
+		Model:        "Powerwall",
+	}
+
+	for _, entity := range b.discoveryEntities() {
+		config := entity.config
+		config.UniqueID = nodeID + "_" + entity.field
+		config.StateTopic = b.topic(entity.field)
+		config.AvailabilityTopic = b.AvailabilityTopic()
+		config.Device = device
+		switch entity.component {
+		case "switch", "number", "select":
+			config.CommandTopic = b.topic(entity.field, "set")
+		}
+
+		payload, err := json.Marshal(config)
+		if err != nil {
+			return err
+		}
+		topic := fmt.Sprintf("%s/%s/%s/%s/config", b.discoveryPrefix, entity.component, nodeID, entity.field)
+		if err := b.publish(topic, string(payload)); err != nil {
+			return fmt.Errorf("publishing discovery config %s: %w", topic, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package optimizer
+
+import "time"
+
+// Interval is the expected household load and solar production over one
+// planning step.
+type Interval struct {
+	Start    time.Time
+	Duration time.Duration
+	LoadWh   float64
+	SolarWh  float64
+}
+
+// Forecast is a sequence of consecutive intervals covering the planning
+// horizon.  The forecast package's OptimizerForecast builds one from power
+// history.
+type Forecast []Interval
@@ -0,0 +1,353 @@
+// Package optimizer plans backup reserve and operation mode changes which
+// minimize grid cost under a time-of-use tariff.
+//
+// The Powerwall can't be told to charge or discharge directly, but in
+// self-powered mode the backup reserve gives the same control: at the minimum
+// reserve the battery covers the home, at the current charge it holds, and at
+// 100% it charges from the grid.  The optimizer chooses one of these actions
+// for each forecast interval using dynamic programming over the battery's
+// state of charge, and turns the result into timed commands:
+//
+//	tariff, _ := optimizer.DecodeTariff(content)
+//	intervals, _ := forecast.New(observations, loc).Forecast(time.Now(), 96)
+//	plan, _ := optimizer.New(tariff, optimizer.WithCapacity(13500)).Plan(soc, forecast.OptimizerForecast(intervals), loc)
+//	for _, cmd := range plan.Commands {
+//		fmt.Println(cmd) // dry run
+//	}
+//	plan.Execute(ctx, client, nil)
+package optimizer
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/blampe/powerwall"
+)
+
+const (
+	// DefaultMinReserve is the default minimum backup reserve percentage.
+	DefaultMinReserve = 20
+
+	// DefaultMaxChargePower is the default grid charging rate in watts.
+	DefaultMaxChargePower = 5000
+
+	// DefaultMaxDischargePower is the default discharge rate in watts.
+	DefaultMaxDischargePower = 11500
+
+	// DefaultRoundTripEfficiency is the default fraction of the energy stored
+	// which can be got back out.
+	DefaultRoundTripEfficiency = 0.9
+
+	// socSteps is the number of discrete states of charge, i.e. a resolution
+	// of 0.5%.
+	socSteps = 200
+)
+
+// Action is what the battery does during one plan step.
+type Action string
+
+const (
+	// ActionSelfPowered lets the battery cover the home down to the minimum
+	// reserve, and charge from excess solar.
+	ActionSelfPowered Action = "self_powered"
+
+	// ActionHold sets the reserve to the current charge, so the home runs on
+	// solar and grid while the battery still charges from excess solar.
+	ActionHold Action = "hold"
+
+	// ActionGridCharge sets the reserve to 100%, charging from the grid.
+	ActionGridCharge Action = "grid_charge"
+)
+
+var actions = []Action{ActionSelfPowered, ActionHold, ActionGridCharge}
+
+// Optimizer plans battery usage for a tariff.
+type Optimizer struct {
+	tariff            *Tariff
+	minReserve        float64
+	capacityWh        float64
+	maxChargeW        float64
+	maxDischargeW     float64
+	efficiency        float64
+	currentOperation  string
+	hasCurrentReserve bool
+	currentReserve    int
+}
+
+// New creates an Optimizer for the tariff.  The battery capacity must be set
+// with WithCapacity.
+func New(tariff *Tariff, options ...func(o *Optimizer)) *Optimizer {
+	o := &Optimizer{
+		tariff:        tariff,
+		minReserve:    DefaultMinReserve,
+		maxChargeW:    DefaultMaxChargePower,
+		maxDischargeW: DefaultMaxDischargePower,
+		efficiency:    DefaultRoundTripEfficiency,
+	}
+
+	// Apply options
+	for _, option := range options {
+		if option != nil {
+			option(o)
+		}
+	}
+
+	return o
+}
+
+// WithMinReserve sets the lowest backup reserve percentage the plan may use
+func WithMinReserve(percent int) func(o *Optimizer) {
+	return func(o *Optimizer) {
+		o.minReserve = float64(percent)
+	}
+}
+
+// WithCapacity sets the usable battery capacity in watt-hours, for example
+// live_status total_pack_energy
+func WithCapacity(wh float64) func(o *Optimizer) {
+	return func(o *Optimizer) {
+		o.capacityWh = wh
+	}
+}
+
+// WithMaxChargePower sets the grid charging rate in watts
+func WithMaxChargePower(watts float64) func(o *Optimizer) {
+	return func(o *Optimizer) {
+		o.maxChargeW = watts
+	}
+}
+
+// WithMaxDischargePower sets the maximum discharge rate in watts
+func WithMaxDischargePower(watts float64) func(o *Optimizer) {
+	return func(o *Optimizer) {
+		o.maxDischargeW = watts
+	}
+}
+
+// WithRoundTripEfficiency sets the fraction of stored energy which can be
+// recovered (0-1)
+func WithRoundTripEfficiency(efficiency float64) func(o *Optimizer) {
+	return func(o *Optimizer) {
+		o.efficiency = efficiency
+	}
+}
+
+// WithCurrentSettings tells the optimizer the site's current operation mode
+// and backup reserve, so the plan doesn't include commands which wouldn't
+// change anything
+func WithCurrentSettings(operationMode string, reservePercent int) func(o *Optimizer) {
+	return func(o *Optimizer) {
+		o.currentOperation = operationMode
+		o.hasCurrentReserve = true
+		o.currentReserve = reservePercent
+	}
+}
+
+// outcome is the result of simulating an action over one interval.
+type outcome struct {
+	state    int
+	importWh float64
+	exportWh float64
+	cost     float64
+}
+
+// Plan chooses an action for every interval of the forecast, starting from
+// the given state of charge (percent).  loc is used to look up tariff prices
+// and should be the site's installation time zone.
+func (o *Optimizer) Plan(socPercent float64, forecast Forecast, loc *time.Location) (*Plan, error) {
+	if o.capacityWh <= 0 {
+		return nil, fmt.Errorf("battery capacity must be set")
+	}
+	if o.efficiency <= 0 || o.efficiency > 1 {
+		return nil, fmt.Errorf("round-trip efficiency must be between 0 and 1, got %v", o.efficiency)
+	}
+	if len(forecast) == 0 {
+		return nil, fmt.Errorf("forecast is empty")
+	}
+
+	n := len(forecast)
+	buy := make([]float64, n)
+	sell := make([]float64, n)
+	minBuy := math.Inf(1)
+	for i, interval := range forecast {
+		var ok bool
+		buy[i], sell[i], ok = o.tariff.Price(interval.Start.In(loc))
+		if !ok {
+			return nil, fmt.Errorf("tariff has no price for %s", interval.Start.In(loc).Format(time.RFC3339))
+		}
+		minBuy = math.Min(minBuy, buy[i])
+	}
+
+	// Energy left in the battery at the end is valued at the cheapest price
+	// it could have been bought for, so the plan neither drains the battery
+	// at the horizon nor charges just to end full.
+	leftover := func(s int) float64 {
+		return -o.energy(s) * math.Sqrt(o.efficiency) * minBuy / 1000
+	}
+	value := make([]float64, socSteps+1)
+	for s := range value {
+		value[s] = leftover(s)
+	}
+
+	// Backward pass: best[i][s] is the best action at step i from state s.
+	best := make([][]Action, n)
+	for i := n - 1; i >= 0; i-- {
+		next := make([]float64, socSteps+1)
+		best[i] = make([]Action, socSteps+1)
+		for s := 0; s <= socSteps; s++ {
+			next[s] = math.Inf(1)
+			for _, action := range actions {
+				out := o.simulate(forecast[i], s, action, buy[i], sell[i])
+				if total := out.cost + value[out.state]; total < next[s]-1e-9 {
+					next[s] = total
+					best[i][s] = action
+				}
+			}
+		}
+		value = next
+	}
+
+	// Forward pass: follow the best actions from the starting state.
+	plan := &Plan{}
+	state := o.state(o.capacityWh * socPercent / 100)
+	baseline := state
+	for i, interval := range forecast {
+		action := best[i][state]
+		out := o.simulate(interval, state, action, buy[i], sell[i])
+		plan.Steps = append(plan.Steps, Step{
+			Start:          interval.Start,
+			Duration:       interval.Duration,
+			Action:         action,
+			ReservePercent: o.reserve(action, state),
+			StartSOC:       o.percent(state),
+			EndSOC:         o.percent(out.state),
+			ImportWh:       out.importWh,
+			ExportWh:       out.exportWh,
+			Cost:           out.cost,
+		})
+		plan.Cost += out.cost
+		state = out.state
+
+		base := o.simulate(interval, baseline, ActionSelfPowered, buy[i], sell[i])
+		plan.BaselineCost += base.cost
+		baseline = base.state
+	}
+
+	plan.Cost += leftover(state)
+	plan.BaselineCost += leftover(baseline)
+
+	plan.Commands = o.commands(plan.Steps)
+	return plan, nil
+}
+
+// simulate models one interval of the given action from state s.
+func (o *Optimizer) simulate(interval Interval, s int, action Action, buy, sell float64) outcome {
+	hours := interval.Duration.Hours()
+	eta := math.Sqrt(o.efficiency) // one-way efficiency
+	energy := o.energy(s)
+	net := interval.LoadWh - interval.SolarWh
+	surplus := math.Max(-net, 0)
+	deficit := math.Max(net, 0)
+
+	var importWh, exportWh float64
+	switch action {
+	case ActionSelfPowered:
+		floor := o.capacityWh * o.minReserve / 100
+		discharge := math.Min(deficit, o.maxDischargeW*hours)
+		discharge = math.Min(discharge, math.Max(energy-floor, 0)*eta)
+		energy -= discharge / eta
+		importWh = deficit - discharge
+		charge := math.Min(surplus, o.chargeRoom(energy, hours))
+		energy += charge * eta
+		exportWh = surplus - charge
+
+	case ActionHold:
+		importWh = deficit
+		charge := math.Min(surplus, o.chargeRoom(energy, hours))
+		energy += charge * eta
+		exportWh = surplus - charge
+
+	case ActionGridCharge:
+		charge := o.chargeRoom(energy, hours)
+		fromSolar := math.Min(surplus, charge)
+		fromGrid := charge - fromSolar
+		energy += charge * eta
+		importWh = deficit + fromGrid
+		exportWh = surplus - fromSolar
+	}
+
+	return outcome{
+		state:    o.state(energy),
+		importWh: importWh,
+		exportWh: exportWh,
+		cost:     (importWh*buy - exportWh*sell) / 1000,
+	}
+}
+
+// chargeRoom returns how much energy can be put into the battery over the
+// given number of hours.
+func (o *Optimizer) chargeRoom(energy, hours float64) float64 {
+	room := (o.capacityWh - energy) / math.Sqrt(o.efficiency)
+	return math.Max(math.Min(room, o.maxChargeW*hours), 0)
+}
+
+// reserve returns the backup reserve percentage which produces the action.
+func (o *Optimizer) reserve(action Action, s int) int {
+	switch action {
+	case ActionHold:
+		// Rounded down, so that holding never starts a grid charge
+		return int(math.Max(math.Floor(o.percent(s)), o.minReserve))
+	case ActionGridCharge:
+		return 100
+	default:
+		return int(o.minReserve)
+	}
+}
+
+// commands turns plan steps into the commands which carry them out.  A
+// command is only issued when the reserve changes, so a hold is re-issued
+// whenever solar has raised the charge it holds; otherwise the battery would
+// discharge down to the reserve set when the hold began.
+func (o *Optimizer) commands(steps []Step) []Command {
+	var commands []Command
+	if len(steps) == 0 {
+		return nil
+	}
+
+	if o.currentOperation != powerwall.OperationModeSelfConsumption {
+		commands = append(commands, Command{
+			At:      steps[0].Start,
+			Setting: SettingOperationMode,
+			Value:   powerwall.OperationModeSelfConsumption,
+		})
+	}
+
+	reserve, known := o.currentReserve, o.hasCurrentReserve
+	for _, step := range steps {
+		if known && step.ReservePercent == reserve {
+			continue
+		}
+		reserve, known = step.ReservePercent, true
+		commands = append(commands, Command{
+			At:      step.Start,
+			Setting: SettingBackupReserve,
+			Value:   fmt.Sprint(step.ReservePercent),
+			Action:  step.Action,
+		})
+	}
+	return commands
+}
+
+func (o *Optimizer) energy(s int) float64 {
+	return o.capacityWh * float64(s) / socSteps
+}
+
+func (o *Optimizer) percent(s int) float64 {
+	return 100 * float64(s) / socSteps
+}
+
+func (o *Optimizer) state(energy float64) int {
+	s := int(math.Round(energy / o.capacityWh * socSteps))
+	return min(max(s, 0), socSteps)
+}
@@ -0,0 +1,107 @@
+package optimizer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/blampe/powerwall"
+)
+
+// Settings changed by plan commands.
+const (
+	SettingBackupReserve = "backup_reserve_percent"
+	SettingOperationMode = "operation_mode"
+)
+
+// Plan is the optimizer's schedule for the forecast horizon.  Costs are in
+// the tariff's currency.
+type Plan struct {
+	Steps    []Step
+	Commands []Command
+
+	// Cost is the expected grid cost of following the plan, and BaselineCost
+	// that of staying self-powered at the minimum reserve throughout.  Both
+	// are net of the energy left in the battery at the end, valued at the
+	// cheapest price in the horizon, so they can be compared.
+	Cost         float64
+	BaselineCost float64
+}
+
+// Step is the planned action for one forecast interval.
+type Step struct {
+	Start          time.Time
+	Duration       time.Duration
+	Action         Action
+	ReservePercent int
+	StartSOC       float64 // percent
+	EndSOC         float64 // percent
+	ImportWh       float64
+	ExportWh       float64
+	Cost           float64
+}
+
+// Command is a setting to apply at a given time.
+type Command struct {
+	At      time.Time
+	Setting string // SettingBackupReserve or SettingOperationMode
+	Value   string
+	Action  Action // The action the command starts, for reserve changes
+}
+
+func (c Command) String() string {
+	s := fmt.Sprintf("%s set %s to %s", c.At.Format(time.RFC3339), c.Setting, c.Value)
+	if c.Action != "" {
+		s += fmt.Sprintf(" (%s)", c.Action)
+	}
+	return s
+}
+
+// Apply carries out the command through the client's Set* methods.
+func (c Command) Apply(client *powerwall.Client) error {
+	return c.ApplyContext(context.Background(), client)
+}
+
+// ApplyContext is like Apply but uses ctx for cancellation and tracing.
+func (c Command) ApplyContext(ctx context.Context, client *powerwall.Client) error {
+	switch c.Setting {
+	case SettingBackupReserve:
+		percent, err := strconv.Atoi(c.Value)
+		if err != nil {
+			return fmt.Errorf("invalid backup reserve %q: %w", c.Value, err)
+		}
+		return client.SetBackupReserveContext(ctx, percent)
+	case SettingOperationMode:
+		return client.SetOperationModeContext(ctx, c.Value)
+	default:
+		return fmt.Errorf("unknown plan setting: %s", c.Setting)
+	}
+}
+
+// Execute applies each command at its scheduled time, applying any which are
+// already due straight away.  The report callback, if not nil, is called
+// after every command with the result.  Execute stops at the first failed
+// command, or when ctx is cancelled.
+func (p *Plan) Execute(ctx context.Context, client *powerwall.Client, report func(Command, error)) error {
+	for _, cmd := range p.Commands {
+		if wait := time.Until(cmd.At); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		err := cmd.ApplyContext(ctx, client)
+		if report != nil {
+			report(cmd, err)
+		}
+		if err != nil {
+			return fmt.Errorf("applying %s: %w", cmd, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package optimizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Tariff is a time-of-use rate plan.  Prices are per kWh in the tariff's
+// currency.
+type Tariff struct {
+	Name    string   `yaml:"name" json:"name"`
+	Seasons []Season `yaml:"seasons" json:"seasons"`
+}
+
+// Season is the part of the year a set of time-of-use periods applies to.
+// From and To are inclusive and may wrap around the end of the year.  A
+// season with a zero FromMonth covers the whole year.
+type Season struct {
+	Name      string   `yaml:"name" json:"name"`
+	FromMonth int      `yaml:"from_month" json:"from_month"`
+	FromDay   int      `yaml:"from_day" json:"from_day"`
+	ToMonth   int      `yaml:"to_month" json:"to_month"`
+	ToDay     int      `yaml:"to_day" json:"to_day"`
+	Periods   []Period `yaml:"periods" json:"periods"`
+}
+
+// Period is a time-of-use window and its prices.  Days of the week follow
+// Tesla's convention of 0 for Monday through 6 for Sunday, and are inclusive.
+// The window runs from the From time up to (not including) the To time and
+// may wrap past midnight; equal times cover the whole day.
+type Period struct {
+	Name          string  `yaml:"name" json:"name"`
+	FromDayOfWeek int     `yaml:"from_day_of_week" json:"from_day_of_week"`
+	ToDayOfWeek   int     `yaml:"to_day_of_week" json:"to_day_of_week"`
+	FromHour      int     `yaml:"from_hour" json:"from_hour"`
+	FromMinute    int     `yaml:"from_minute" json:"from_minute"`
+	ToHour        int     `yaml:"to_hour" json:"to_hour"`
+	ToMinute      int     `yaml:"to_minute" json:"to_minute"`
+	Buy           float64 `yaml:"buy" json:"buy"`
+	Sell          float64 `yaml:"sell" json:"sell"`
+}
+
+// LoadTariff reads a Tariff from a YAML file, for sites without a rate plan
+// in the Tesla app or to try out a different plan.
+func LoadTariff(path string) (*Tariff, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tariff Tariff
+	if err := yaml.Unmarshal(data, &tariff); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(tariff.Seasons) == 0 {
+		return nil, fmt.Errorf("tariff %s has no seasons", path)
+	}
+	return &tariff, nil
+}
+
+// Price returns the buy and sell prices at t, which should be in the site's
+// local time.  ok is false if no period covers t.
+func (t *Tariff) Price(at time.Time) (buy, sell float64, ok bool) {
+	for _, season := range t.Seasons {
+		if !season.contains(at) {
+			continue
+		}
+		for _, period := range season.Periods {
+			if period.contains(at) {
+				return period.Buy, period.Sell, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+func (s Season) contains(t time.Time) bool {
+	if s.FromMonth == 0 {
+		return true
+	}
+	day := int(t.Month())*100 + t.Day()
+	from := s.FromMonth*100 + s.FromDay
+	to := s.ToMonth*100 + s.ToDay
+	if from <= to {
+		return day >= from && day <= to
+	}
+	return day >= from || day <= to
+}
+
+func (p Period) contains(t time.Time) bool {
+	weekday := (int(t.Weekday()) + 6) % 7 // Monday is 0
+	if p.FromDayOfWeek <= p.ToDayOfWeek {
+		if weekday < p.FromDayOfWeek || weekday > p.ToDayOfWeek {
+			return false
+		}
+	} else if weekday < p.FromDayOfWeek && weekday > p.ToDayOfWeek {
+		return false
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	from := p.FromHour*60 + p.FromMinute
+	to := p.ToHour*60 + p.ToMinute
+	switch {
+	case from == to:
+		return true
+	case from < to:
+		return minute >= from && minute < to
+	default:
+		return minute >= from || minute < to
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Tesla tariff_content
+
+// teslaTariff is the subset of Tesla's tariff_content format we need.  Buy
+// prices are in energy_charges[season][period] and sell prices in the same
+// place under sell_tariff.  The periods of each season are listed under
+// seasons[season].tou_periods[period].
+type teslaTariff struct {
+	Name          string                  `json:"name"`
+	EnergyCharges map[string]teslaCharges `json:"energy_charges"`
+	Seasons       map[string]teslaSeason  `json:"seasons"`
+	SellTariff    *struct {
+		EnergyCharges map[string]teslaCharges `json:"energy_charges"`
+	} `json:"sell_tariff"`
+}
+
+type teslaSeason struct {
+	FromMonth  int                        `json:"fromMonth"`
+	FromDay    int                        `json:"fromDay"`
+	ToMonth    int                        `json:"toMonth"`
+	ToDay      int                        `json:"toDay"`
+	TOUPeriods map[string]json.RawMessage `json:"tou_periods"`
+}
+
+type teslaPeriod struct {
+	FromDayOfWeek int `json:"fromDayOfWeek"`
+	ToDayOfWeek   int `json:"toDayOfWeek"`
+	FromHour      int `json:"fromHour"`
+	FromMinute    int `json:"fromMinute"`
+	ToHour        int `json:"toHour"`
+	ToMinute      int `json:"toMinute"`
+}
+
+// teslaCharges are the prices for each period of a season.  Older tariffs
+// map periods to prices directly; newer ones nest them under "rates".
+type teslaCharges map[string]float64
+
+func (c *teslaCharges) UnmarshalJSON(data []byte) error {
+	var nested struct {
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.Unmarshal(data, &nested); err == nil && nested.Rates != nil {
+		*c = nested.Rates
+		return nil
+	}
+	var flat map[string]float64
+	if err := json.Unmarshal(data, &flat); err != nil {
+		return err
+	}
+	*c = flat
+	return nil
+}
+
+// DecodeTariff converts Tesla's tariff_content (see
+// powerwall.Client.GetTariffContent) to a Tariff.  A flat "ALL" charge, if
+// present, is added to every period's price.
+func DecodeTariff(content map[string]interface{}) (*Tariff, error) {
+	if len(content) == 0 {
+		return nil, fmt.Errorf("no tariff configured for this site")
+	}
+
+	data, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	var raw teslaTariff
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("decoding tariff: %w", err)
+	}
+
+	var sellCharges map[string]teslaCharges
+	if raw.SellTariff != nil {
+		sellCharges = raw.SellTariff.EnergyCharges
+	}
+
+	tariff := &Tariff{Name: raw.Name}
+	for _, seasonName := range sortedKeys(raw.Seasons) {
+		rs := raw.Seasons[seasonName]
+		if len(rs.TOUPeriods) == 0 {
+			continue
+		}
+		season := Season{
+			Name:      seasonName,
+			FromMonth: rs.FromMonth,
+			FromDay:   rs.FromDay,
+			ToMonth:   rs.ToMonth,
+			ToDay:     rs.ToDay,
+		}
+
+		for _, periodName := range sortedKeys(rs.TOUPeriods) {
+			windows, err := decodePeriods(rs.TOUPeriods[periodName])
+			if err != nil {
+				return nil, fmt.Errorf("decoding tariff period %s/%s: %w", seasonName, periodName, err)
+			}
+			buy := charge(raw.EnergyCharges, seasonName, periodName)
+			sell := charge(sellCharges, seasonName, periodName)
+			for _, w := range windows {
+				season.Periods = append(season.Periods, Period{
+					Name:          periodName,
+					FromDayOfWeek: w.FromDayOfWeek,
+					ToDayOfWeek:   w.ToDayOfWeek,
+					FromHour:      w.FromHour,
+					FromMinute:    w.FromMinute,
+					ToHour:        w.ToHour,
+					ToMinute:      w.ToMinute,
+					Buy:           buy,
+					Sell:          sell,
+				})
+			}
+		}
+		tariff.Seasons = append(tariff.Seasons, season)
+	}
+
+	// A flat rate plan only has the "ALL" charge
+	if len(tariff.Seasons) == 0 {
+		if _, ok := raw.EnergyCharges["ALL"]["ALL"]; !ok {
+			return nil, fmt.Errorf("tariff %s has no time-of-use periods", raw.Name)
+		}
+		tariff.Seasons = []Season{{
+			Name: "ALL",
+			Periods: []Period{{
+				Name:        "ALL",
+				ToDayOfWeek: 6,
+				Buy:         charge(raw.EnergyCharges, "", ""),
+				Sell:        charge(sellCharges, "", ""),
+			}},
+		}}
+	}
+	return tariff, nil
+}
+
+// decodePeriods accepts both a bare list of windows and the newer
+// {"periods": [...]} form.
+func decodePeriods(data json.RawMessage) ([]teslaPeriod, error) {
+	var list []teslaPeriod
+	if err := json.Unmarshal(data, &list); err == nil {
+		return list, nil
+	}
+	var nested struct {
+		Periods []teslaPeriod `json:"periods"`
+	}
+	if err := json.Unmarshal(data, &nested); err != nil {
+		return nil, err
+	}
+	return nested.Periods, nil
+}
+
+// charge returns the price of a season's period plus any flat "ALL" charge.
+func charge(charges map[string]teslaCharges, season, period string) float64 {
+	return charges[season][period] + charges["ALL"]["ALL"]
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
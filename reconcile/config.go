@@ -0,0 +1,138 @@
+package reconcile
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/blampe/powerwall"
+	"gopkg.in/yaml.v3"
+)
+
+// State is the desired configuration of an energy site.  Nil fields are left
+// as they are.
+type State struct {
+	SiteName      *string `yaml:"site_name,omitempty"`
+	BackupReserve *int    `yaml:"backup_reserve_percent,omitempty"`
+	StormMode     *bool   `yaml:"storm_mode,omitempty"`
+	OperationMode *string `yaml:"operation_mode,omitempty"`
+	ExportRule    *string `yaml:"export_rule,omitempty"`
+	GridCharging  *bool   `yaml:"grid_charging,omitempty"`
+}
+
+// Config is the YAML desired-state file.  Settings at the top level apply to
+// every site; settings under sites, keyed by energy_site_id, override them
+// for one site:
+//
+//	backup_reserve_percent: 20
+//	storm_mode: true
+//	sites:
+//	  12345:
+//	    site_name: Main St
+//	    operation_mode: autonomous
+//	    export_rule: battery_ok
+//	  67890:
+//	    backup_reserve_percent: 50
+//	    grid_charging: false
+//
+// A file without sites applies to the selected site only.
+type Config struct {
+	State `yaml:",inline"`
+	Sites map[int64]State `yaml:"sites"`
+}
+
+// LoadConfig reads a YAML desired-state file from path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// Resolve returns the desired state of each site, with the top-level
+// settings applied to every site.  If the config doesn't list any sites,
+// the top-level settings apply to defaultSiteID.
+func (c *Config) Resolve(defaultSiteID int64) map[int64]State {
+	if len(c.Sites) == 0 {
+		return map[int64]State{defaultSiteID: c.State}
+	}
+
+	states := make(map[int64]State, len(c.Sites))
+	for id, site := range c.Sites {
+		states[id] = c.State.merge(site)
+	}
+	return states
+}
+
+// merge returns s with the fields set in override replaced.
+func (s State) merge(override State) State {
+	if override.SiteName != nil {
+		s.SiteName = override.SiteName
+	}
+	if override.BackupReserve != nil {
+		s.BackupReserve = override.BackupReserve
+	}
+	if override.StormMode != nil {
+		s.StormMode = override.StormMode
+	}
+	if override.OperationMode != nil {
+		s.OperationMode = override.OperationMode
+	}
+	if override.ExportRule != nil {
+		s.ExportRule = override.ExportRule
+	}
+	if override.GridCharging != nil {
+		s.GridCharging = override.GridCharging
+	}
+	return s
+}
+
+func (c *Config) validate() error {
+	if err := c.State.validate(); err != nil {
+		return err
+	}
+	ids := make([]int64, 0, len(c.Sites))
+	for id := range c.Sites {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		if err := c.Sites[id].validate(); err != nil {
+			return fmt.Errorf("site %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+func (s State) validate() error {
+	if s.SiteName != nil && *s.SiteName == "" {
+		return fmt.Errorf("site_name cannot be empty")
+	}
+	if s.BackupReserve != nil && (*s.BackupReserve < 0 || *s.BackupReserve > 100) {
+		return fmt.Errorf("backup_reserve_percent must be between 0 and 100, got %d", *s.BackupReserve)
+	}
+	if s.OperationMode != nil {
+		switch *s.OperationMode {
+		case powerwall.OperationModeSelfConsumption, powerwall.OperationModeAutonomous, powerwall.OperationModeBackup:
+		default:
+			return fmt.Errorf("invalid operation_mode: %s", *s.OperationMode)
+		}
+	}
+	if s.ExportRule != nil {
+		switch *s.ExportRule {
+		case powerwall.ExportRuleBatteryOK, powerwall.ExportRulePVOnly, powerwall.ExportRuleNever:
+		default:
+			return fmt.Errorf("invalid export_rule: %s", *s.ExportRule)
+		}
+	}
+	return nil
+}
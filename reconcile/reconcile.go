@@ -0,0 +1,225 @@
+// Package reconcile applies a declared configuration to energy sites.
+//
+// The desired backup reserve, Storm Watch, operation mode, export rule, grid
+// charging and site name of one or more sites are declared in a Config.
+// NewPlan compares them with site_info and lists the settings which differ,
+// and Apply issues only the commands needed to change them:
+//
+//	config, _ := reconcile.LoadConfig("sites.yaml")
+//	plan, _ := reconcile.NewPlan(client, config)
+//	fmt.Print(plan)
+//	err := plan.Apply(client, nil)
+package reconcile
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/blampe/powerwall"
+)
+
+// Settings in the order they are applied.  The operation mode goes first
+// because changing it can reset the backup reserve.
+const (
+	SettingOperationMode = "operation_mode"
+	SettingBackupReserve = "backup_reserve_percent"
+	SettingStormMode     = "storm_mode"
+	SettingExportRule    = "export_rule"
+	SettingGridCharging  = "grid_charging"
+	SettingSiteName      = "site_name"
+)
+
+var settingOrder = []string{
+	SettingOperationMode,
+	SettingBackupReserve,
+	SettingStormMode,
+	SettingExportRule,
+	SettingGridCharging,
+	SettingSiteName,
+}
+
+// Change is a setting which differs from the desired state.
+type Change struct {
+	SiteID  int64
+	Setting string
+	From    string
+	To      string
+
+	value interface{}
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Setting, c.From, c.To)
+}
+
+// SitePlan is the changes needed for one energy site.
+type SitePlan struct {
+	SiteID   int64
+	SiteName string
+	Changes  []Change
+}
+
+// Plan is the changes needed to bring every site to its desired state.
+type Plan struct {
+	Sites []SitePlan
+}
+
+// NewPlan reads the current configuration of every site in config and
+// returns the changes needed.  A config without sites applies to the
+// client's selected site.  The client's selected site is changed.
+func NewPlan(client *powerwall.Client, config *Config) (*Plan, error) {
+	states := config.Resolve(client.GetSelectedEnergySite())
+
+	ids := make([]int64, 0, len(states))
+	for id := range states {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	plan := &Plan{}
+	for _, id := range ids {
+		if id == 0 {
+			return nil, fmt.Errorf("no energy site selected")
+		}
+		if err := client.SelectEnergySite(id); err != nil {
+			return nil, err
+		}
+		current, err := client.GetSiteConfig()
+		if err != nil {
+			return nil, fmt.Errorf("site %d: %w", id, err)
+		}
+		plan.Sites = append(plan.Sites, diff(id, current, states[id]))
+	}
+	return plan, nil
+}
+
+// diff compares the current configuration of a site with its desired state.
+func diff(siteID int64, current *powerwall.SiteConfig, desired State) SitePlan {
+	site := SitePlan{SiteID: siteID, SiteName: current.SiteName}
+	add := func(setting, from, to string, value interface{}) {
+		if from != to {
+			site.Changes = append(site.Changes, Change{SiteID: siteID, Setting: setting, From: from, To: to, value: value})
+		}
+	}
+
+	if desired.OperationMode != nil {
+		add(SettingOperationMode, current.DefaultRealMode, *desired.OperationMode, *desired.OperationMode)
+	}
+	if desired.BackupReserve != nil {
+		from := "unknown"
+		if current.BackupReservePercent != nil {
+			from = strconv.Itoa(*current.BackupReservePercent)
+		}
+		add(SettingBackupReserve, from, strconv.Itoa(*desired.BackupReserve), *desired.BackupReserve)
+	}
+	if desired.StormMode != nil {
+		from := "unknown"
+		if enabled, ok := current.UserSettings["storm_mode_enabled"].(bool); ok {
+			from = strconv.FormatBool(enabled)
+		}
+		add(SettingStormMode, from, strconv.FormatBool(*desired.StormMode), *desired.StormMode)
+	}
+	if desired.ExportRule != nil {
+		add(SettingExportRule, current.Components.CustomerPreferredExportRule, *desired.ExportRule, *desired.ExportRule)
+	}
+	if desired.GridCharging != nil {
+		allowed := !current.Components.DisallowChargeFromGridWithSolarInstalled
+		add(SettingGridCharging, strconv.FormatBool(allowed), strconv.FormatBool(*desired.GridCharging), *desired.GridCharging)
+	}
+	if desired.SiteName != nil {
+		add(SettingSiteName, current.SiteName, *desired.SiteName, *desired.SiteName)
+	}
+
+	sort.SliceStable(site.Changes, func(i, j int) bool {
+		return settingIndex(site.Changes[i].Setting) < settingIndex(site.Changes[j].Setting)
+	})
+	return site
+}
+
+func settingIndex(setting string) int {
+	for i, s := range settingOrder {
+		if s == setting {
+			return i
+		}
+	}
+	return len(settingOrder)
+}
+
+// Changes returns the number of changes in the plan.
+func (p *Plan) Changes() int {
+	n := 0
+	for _, site := range p.Sites {
+		n += len(site.Changes)
+	}
+	return n
+}
+
+// String formats the plan for humans, in the style of "terraform plan".
+func (p *Plan) String() string {
+	var b strings.Builder
+	unchanged := 0
+	for _, site := range p.Sites {
+		if len(site.Changes) == 0 {
+			unchanged++
+			continue
+		}
+		fmt.Fprintf(&b, "Energy site %d (%s):\n", site.SiteID, site.SiteName)
+		for _, change := range site.Changes {
+			fmt.Fprintf(&b, "  ~ %s\n", change)
+		}
+		fmt.Fprintln(&b)
+	}
+	if p.Changes() == 0 {
+		fmt.Fprintf(&b, "No changes. %d site(s) match the desired state.\n", unchanged)
+	} else {
+		fmt.Fprintf(&b, "Plan: %d to change on %d site(s), %d site(s) unchanged.\n",
+			p.Changes(), len(p.Sites)-unchanged, unchanged)
+	}
+	return b.String()
+}
+
+// Apply issues the commands in the plan, in order.  report, if not nil, is
+// called after each command with its error, if any.  Apply stops at the
+// first failure, since later settings may depend on earlier ones.  The
+// client's selected site is changed.
+func (p *Plan) Apply(client *powerwall.Client, report func(Change, error)) error {
+	for _, site := range p.Sites {
+		if len(site.Changes) == 0 {
+			continue
+		}
+		if err := client.SelectEnergySite(site.SiteID); err != nil {
+			return err
+		}
+		for _, change := range site.Changes {
+			err := change.apply(client)
+			if report != nil {
+				report(change, err)
+			}
+			if err != nil {
+				return fmt.Errorf("site %d: %s: %w", site.SiteID, change.Setting, err)
+			}
+		}
+	}
+	return nil
+}
+
+// apply issues the command for a change to the selected site.
+func (c Change) apply(client *powerwall.Client) error {
+	switch c.Setting {
+	case SettingOperationMode:
+		return client.SetOperationMode(c.value.(string))
+	case SettingBackupReserve:
+		return client.SetBackupReserve(c.value.(int))
+	case SettingStormMode:
+		return client.SetStormMode(c.value.(bool))
+	case SettingExportRule:
+		return client.SetExportRule(c.value.(string))
+	case SettingGridCharging:
+		return client.SetGridCharging(c.value.(bool))
+	case SettingSiteName:
+		return client.SetSiteName(c.value.(string))
+	}
+	return fmt.Errorf("unknown setting: %s", c.Setting)
+}
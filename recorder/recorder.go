@@ -0,0 +1,209 @@
+// Package recorder keeps a local time series of Powerwall samples.
+//
+// Tesla only retains fine-grained data for a limited window and the history
+// endpoints are rate-limited, so a Recorder polls live_status at a fixed
+// interval and appends each sample to a local Store.  When the recorder has
+// been down for longer than its gap threshold, the missing range is backfilled
+// from the calendar_history endpoint before polling resumes.
+//
+//	New(client, store, options...) - Creates a recorder for the selected site
+//	(*Recorder) Run(ctx)           - Polls until ctx is cancelled
+//	(*Recorder) Sample()           - Records a single live_status sample
+//	(*Recorder) Backfill(from, to) - Fills a gap from calendar_history
+//	(*Recorder) Range(start, end)  - Returns recorded points
+//
+// Sample and Backfill have ...Context variants which use ctx for cancellation
+// and tracing.
+package recorder
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/blampe/powerwall"
+)
+
+const (
+	// DefaultInterval is the default polling interval for live_status.
+	DefaultInterval = time.Minute
+
+	// DefaultGapThreshold is the default gap between samples above which the
+	// recorder backfills from calendar_history.  Tesla's power history is
+	// reported in 15-minute intervals, so smaller gaps can't be filled anyway.
+	DefaultGapThreshold = 15 * time.Minute
+)
+
+// Recorder polls the selected energy site of a powerwall.Client and appends
+// samples to a Store.
+type Recorder struct {
+	client       *powerwall.Client
+	store        Store
+	interval     time.Duration
+	gapThreshold time.Duration
+	errHandler   func(error)
+}
+
+// New creates a Recorder which samples the client's currently selected energy
+// site into store.
+func New(client *powerwall.Client, store Store, options ...func(r *Recorder)) *Recorder {
+	r := &Recorder{
+		client:       client,
+		store:        store,
+		interval:     DefaultInterval,
+		gapThreshold: DefaultGapThreshold,
+		errHandler:   func(error) {},
+	}
+
+	// Apply options
+	for _, option := range options {
+		if option != nil {
+			option(r)
+		}
+	}
+
+	return r
+}
+
+// WithInterval sets the live_status polling interval
+func WithInterval(interval time.Duration) func(r *Recorder) {
+	return func(r *Recorder) {
+		r.interval = interval
+	}
+}
+
+// WithGapThreshold sets the gap between samples above which the recorder
+// backfills from calendar_history
+func WithGapThreshold(threshold time.Duration) func(r *Recorder) {
+	return func(r *Recorder) {
+		r.gapThreshold = threshold
+	}
+}
+
+// WithErrorHandler registers a callback for failed samples and backfills.  A
+// failed backfill leaves a gap in the store; a failed sample, including a
+// live_status without a timestamp, is skipped until the next tick.
+func WithErrorHandler(f func(error)) func(r *Recorder) {
+	return func(r *Recorder) {
+		r.errHandler = f
+	}
+}
+
+// Run samples live_status every interval until ctx is cancelled.  Any gap
+// since the last stored point is backfilled first.
+func (r *Recorder) Run(ctx context.Context) error {
+	if last, ok, err := r.store.Last(); err != nil {
+		return err
+	} else if ok && time.Since(last) > r.gapThreshold {
+		if err := r.BackfillContext(ctx, last, time.Now()); err != nil {
+			r.errHandler(err)
+		}
+	}
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.SampleContext(ctx); err != nil {
+			r.errHandler(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sample fetches live_status once and appends it to the store.  If the
+// previous stored point is older than the gap threshold, the gap is backfilled
+// before the new sample is written.
+func (r *Recorder) Sample() error {
+	return r.SampleContext(context.Background())
+}
+
+// SampleContext is like Sample but uses ctx for cancellation and tracing.
+func (r *Recorder) SampleContext(ctx context.Context) error {
+	status, err := r.client.GetLiveStatusContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if status.Timestamp.IsZero() {
+		return fmt.Errorf("live_status has no timestamp, sample skipped")
+	}
+	point := TimePointFromLiveStatus(status)
+
+	last, ok, err := r.store.Last()
+	if err != nil {
+		return err
+	}
+	if ok && !point.Timestamp.After(last) {
+		// Gateway hasn't reported anything new since the last sample
+		return nil
+	}
+	if ok && point.Timestamp.Sub(last) > r.gapThreshold {
+		if err := r.BackfillContext(ctx, last, point.Timestamp); err != nil {
+			r.errHandler(err)
+		}
+	}
+
+	return r.store.Append(point)
+}
+
+// Backfill fetches power history for the open interval (from, to) and appends
+// it to the store.  The range is requested one day at a time, which is the
+// finest granularity the calendar_history endpoint supports.
+func (r *Recorder) Backfill(from, to time.Time) error {
+	return r.BackfillContext(context.Background(), from, to)
+}
+
+// BackfillContext is like Backfill but uses ctx for cancellation and tracing.
+func (r *Recorder) BackfillContext(ctx context.Context, from, to time.Time) error {
+	for start := from; start.Before(to); start = start.Add(24 * time.Hour) {
+		end := start.Add(24 * time.Hour)
+		if end.After(to) {
+			end = to
+		}
+
+		history, err := r.client.GetPowerHistoryContext(ctx, start.Format(time.RFC3339), end.Format(time.RFC3339), "day")
+		if err != nil {
+			return err
+		}
+
+		var points []powerwall.TimePoint
+		for _, p := range history.TimeSeries {
+			if p.Timestamp.After(from) && p.Timestamp.Before(to) {
+				points = append(points, p)
+			}
+		}
+		if len(points) == 0 {
+			continue
+		}
+		if err := r.store.Append(points...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Range returns recorded points with start <= Timestamp < end.
+func (r *Recorder) Range(start, end time.Time) ([]powerwall.TimePoint, error) {
+	return r.store.Range(start, end)
+}
+
+// TimePointFromLiveStatus converts a live_status snapshot into the TimePoint
+// representation used by the history endpoints.  LoadPower is left out:
+// TimePoint has no load field because power history doesn't report one, so
+// recorded and backfilled points look alike.  Load is the balance of the
+// other fields, solar + battery + grid, as forecast.ObservationsFromHistory
+// derives it.
+func TimePointFromLiveStatus(status *powerwall.LiveStatus) powerwall.TimePoint {
+	return powerwall.TimePoint{
+		Timestamp:    status.Timestamp,
+		SolarPower:   status.SolarPower,
+		BatteryPower: status.BatteryPower,
+		GridPower:    status.GridPower,
+	}
+}
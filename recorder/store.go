@@ -0,0 +1,191 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/blampe/powerwall"
+)
+
+// Store is the interface used by the Recorder to persist samples.  Points are
+// appended roughly in chronological order, but implementations must not rely
+// on that when answering range queries.
+type Store interface {
+	// Append persists one or more points.
+	Append(points ...powerwall.TimePoint) error
+
+	// Range returns all points with start <= Timestamp < end, sorted by
+	// timestamp.
+	Range(start, end time.Time) ([]powerwall.TimePoint, error)
+
+	// Last returns the timestamp of the most recent stored point.  The
+	// boolean result is false if the store is empty.
+	Last() (time.Time, bool, error)
+}
+
+// FileStore is a Store backed by an append-only JSON Lines file, with one
+// TimePoint per line.  It is safe for concurrent use.
+type FileStore struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+	last time.Time
+}
+
+// OpenFileStore opens (or creates) the JSON Lines file at path for appending.
+// A truncated final line left by a crash in the middle of a write is removed,
+// so that new points start on a line of their own.
+func OpenFileStore(path string) (*FileStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := truncatePartialLine(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	s := &FileStore{
+		path: path,
+		file: f,
+	}
+
+	// Scan the existing file once so Last() doesn't have to.
+	err = s.scan(func(p powerwall.TimePoint) {
+		if p.Timestamp.After(s.last) {
+			s.last = p.Timestamp
+		}
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Close closes the underlying file.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// Append writes the given points to the end of the file.
+func (s *FileStore) Append(points ...powerwall.TimePoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := bufio.NewWriter(s.file)
+	enc := json.NewEncoder(w)
+	for _, p := range points {
+		if err := enc.Encode(p); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for _, p := range points {
+		if p.Timestamp.After(s.last) {
+			s.last = p.Timestamp
+		}
+	}
+	return nil
+}
+
+// Range returns all points with start <= Timestamp < end, sorted by timestamp.
+func (s *FileStore) Range(start, end time.Time) ([]powerwall.TimePoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []powerwall.TimePoint
+	err := s.scan(func(p powerwall.TimePoint) {
+		if !p.Timestamp.Before(start) && p.Timestamp.Before(end) {
+			result = append(result, p)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+	return result, nil
+}
+
+// Last returns the timestamp of the most recent stored point.
+func (s *FileStore) Last() (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last, !s.last.IsZero(), nil
+}
+
+// scan calls fn for every point in the file.  A truncated final line (for
+// example, from a crash in the middle of a write) is ignored.
+func (s *FileStore) scan(fn func(powerwall.TimePoint)) error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for lineNum := 1; ; lineNum++ {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// Anything without a trailing newline is an incomplete write
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var p powerwall.TimePoint
+		if err := json.Unmarshal(line, &p); err != nil {
+			return fmt.Errorf("%s:%d: %w", s.path, lineNum, err)
+		}
+		fn(p)
+	}
+}
+
+// truncatePartialLine truncates f after its last newline, dropping any
+// incomplete final line.
+func truncatePartialLine(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	const chunkSize = 4096
+	buf := make([]byte, chunkSize)
+	end := info.Size()
+	for end > 0 {
+		start := max(end-chunkSize, 0)
+		n, err := f.ReadAt(buf[:end-start], start)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			end = start + int64(i) + 1
+			break
+		}
+		end = start
+	}
+
+	if end == info.Size() {
+		return nil
+	}
+	return f.Truncate(end)
+}
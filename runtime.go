@@ -0,0 +1,149 @@
+// Backup runtime estimation
+//
+//	(*Client) EstimateBackupRuntime(loadWindow...) - Hours of autonomy in an outage
+
+package powerwall
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DefaultLoadWindow is the period of recent power history averaged by
+// EstimateBackupRuntime.
+const DefaultLoadWindow = 24 * time.Hour
+
+// BackupRuntime estimates how long the battery could run the home if the grid
+// went down now.
+type BackupRuntime struct {
+	// EnergyLeftWh is the energy currently stored, all of which is available
+	// during an outage.
+	EnergyLeftWh    float64 `json:"energy_left_wh"`
+	TotalPackEnergy float64 `json:"total_pack_energy_wh"`
+
+	// CurrentLoadW is the load right now, and AverageLoadW the average load
+	// over LoadWindow of power history.  If there was no history, the
+	// average is the current load.
+	CurrentLoadW float64       `json:"current_load_w"`
+	AverageLoadW float64       `json:"average_load_w"`
+	LoadWindow   time.Duration `json:"load_window"`
+
+	// Hours is the runtime at the average load, and CurrentLoadHours the
+	// runtime if the current load continued.  Solar production during the
+	// outage is not counted.
+	Hours            float64 `json:"hours"`
+	CurrentLoadHours float64 `json:"current_load_hours"`
+
+	// ReservePercent is the backup reserve, and ReserveHours the runtime at
+	// the average load if an outage began with the battery at the reserve.
+	// Both are nil if the reserve couldn't be read.
+	ReservePercent *int     `json:"reserve_percent,omitempty"`
+	ReserveHours   *float64 `json:"reserve_hours,omitempty"`
+}
+
+// EstimateBackupRuntime combines live_status with the average load from
+// recent power history to estimate the hours of autonomy in an outage.
+// loadWindow optionally sets how much history to average (default 24h); a
+// zero window uses only the current load.
+func (c *Client) EstimateBackupRuntime(loadWindow ...time.Duration) (*BackupRuntime, error) {
+	return c.EstimateBackupRuntimeContext(context.Background(), loadWindow...)
+}
+
+// EstimateBackupRuntimeContext is like EstimateBackupRuntime but uses ctx for cancellation and tracing.
+func (c *Client) EstimateBackupRuntimeContext(ctx context.Context, loadWindow ...time.Duration) (*BackupRuntime, error) {
+	window := DefaultLoadWindow
+	if len(loadWindow) > 0 {
+		window = loadWindow[0]
+	}
+
+	status, err := c.GetLiveStatusContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if status.EnergyLeft == nil || status.TotalPackEnergy == nil || status.LoadPower == nil {
+		return nil, fmt.Errorf("live_status for energy site %d did not include energy_left, total_pack_energy and load_power", c.selectedSiteID)
+	}
+
+	runtime := &BackupRuntime{
+		EnergyLeftWh:    *status.EnergyLeft,
+		TotalPackEnergy: *status.TotalPackEnergy,
+		CurrentLoadW:    *status.LoadPower,
+		AverageLoadW:    *status.LoadPower,
+	}
+
+	if window > 0 {
+		average, ok, err := c.averageLoad(ctx, time.Now().Add(-window), time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			runtime.AverageLoadW = average
+			runtime.LoadWindow = window
+		} else {
+			c.logf("No power history in the last %s, estimating backup runtime from the current load", window)
+		}
+	}
+
+	if runtime.AverageLoadW <= 0 {
+		return nil, fmt.Errorf("can't estimate backup runtime without any load")
+	}
+	runtime.Hours = runtime.EnergyLeftWh / runtime.AverageLoadW
+	if runtime.CurrentLoadW > 0 {
+		runtime.CurrentLoadHours = runtime.EnergyLeftWh / runtime.CurrentLoadW
+	}
+
+	// The reserve is extra information, so failing to read it isn't fatal
+	reserve, err := c.GetBackupReserveContext(ctx)
+	if err != nil {
+		c.logf("Backup reserve unavailable for runtime estimate: %s", err)
+	} else {
+		hours := runtime.TotalPackEnergy * float64(reserve) / 100 / runtime.AverageLoadW
+		runtime.ReservePercent = &reserve
+		runtime.ReserveHours = &hours
+	}
+
+	c.logf("Estimated backup runtime: %.1f hours at %.0f W", runtime.Hours, runtime.AverageLoadW)
+	return runtime, nil
+}
+
+// averageLoad returns the mean household load in watts over [start, end)
+// from power history, where load is the sum of the solar, battery, grid and
+// generator power.  ok is false if there were no samples.
+func (c *Client) averageLoad(ctx context.Context, start, end time.Time) (average float64, ok bool, err error) {
+	var sum float64
+	var count int
+	for chunk := start; chunk.Before(end); chunk = chunk.Add(24 * time.Hour) {
+		chunkEnd := chunk.Add(24 * time.Hour)
+		if chunkEnd.After(end) {
+			chunkEnd = end
+		}
+
+		history, err := c.GetPowerHistoryContext(ctx, chunk.Format(time.RFC3339), chunkEnd.Format(time.RFC3339), "day")
+		if err != nil {
+			return 0, false, err
+		}
+		for _, p := range history.TimeSeries {
+			if p.Timestamp.Before(start) || !p.Timestamp.Before(end) {
+				continue
+			}
+			if p.SolarPower == nil && p.BatteryPower == nil && p.GridPower == nil {
+				continue
+			}
+			sum += deref(p.SolarPower) + deref(p.BatteryPower) + deref(p.GridPower) + deref(p.GeneratorPower)
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0, false, nil
+	}
+	return sum / float64(count), true, nil
+}
+
+func deref(v *float64) float64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
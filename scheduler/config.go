@@ -0,0 +1,58 @@
+package scheduler
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/blampe/powerwall"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the YAML schedule configuration:
+//
+//	# Defaults to the site's installation time zone
+//	time_zone: America/Los_Angeles
+//	entries:
+//	  - name: peak
+//	    cron: "0 16 * * 1-5"
+//	    backup_reserve_percent: 80
+//	    operation_mode: autonomous
+//	  - name: off-peak
+//	    cron: "0 21 * * *"
+//	    backup_reserve_percent: 20
+//	    operation_mode: self_consumption
+//	  - name: hurricane-season
+//	    cron: "0 0 1 6 *"
+//	    storm_mode: true
+type Config struct {
+	TimeZone string  `yaml:"time_zone"`
+	Entries  []Entry `yaml:"entries"`
+}
+
+// LoadConfig reads a YAML schedule configuration from path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// NewFromConfig creates a Scheduler for the configured entries.  The
+// configured time zone is used unless options override it.
+func NewFromConfig(client *powerwall.Client, config *Config, options ...func(s *Scheduler)) (*Scheduler, error) {
+	if config.TimeZone != "" {
+		loc, err := time.LoadLocation(config.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time_zone: %w", err)
+		}
+		options = append([]func(s *Scheduler){WithLocation(loc)}, options...)
+	}
+	return New(client, config.Entries, options...)
+}
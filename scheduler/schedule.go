@@ -0,0 +1,108 @@
+package scheduler
+
+import (
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// schedule wraps a parsed cron expression with the daylight saving rules of
+// traditional cron daemons:
+//
+//   - Entries with a specific hour run once on the wall-clock time they name.
+//     If that time is skipped when clocks go forward, they run at the moment
+//     of the change instead; if it repeats when clocks go back, they only run
+//     the first time.
+//   - Entries which run every hour (a wildcard hour field) follow elapsed time
+//     and are unaffected.
+type schedule struct {
+	cron         cron.Schedule
+	wildcardHour bool
+}
+
+// parseSchedule parses a standard 5-field cron expression or one of the
+// "@daily" style descriptors.
+func parseSchedule(spec string) (schedule, error) {
+	parsed, err := cron.ParseStandard(spec)
+	if err != nil {
+		return schedule{}, err
+	}
+	return schedule{cron: parsed, wildcardHour: hasWildcardHour(spec)}, nil
+}
+
+func hasWildcardHour(spec string) bool {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return false
+	}
+	if strings.HasPrefix(fields[0], "@") {
+		return fields[0] == "@hourly" || fields[0] == "@every"
+	}
+	return len(fields) > 1 && strings.HasPrefix(fields[1], "*")
+}
+
+// next returns the first activation in loc strictly after the given time.
+func (s schedule) next(after time.Time, loc *time.Location) time.Time {
+	after = after.In(loc)
+	t := s.cron.Next(after)
+	if s.wildcardHour || t.IsZero() {
+		return t
+	}
+
+	if skipped, ok := s.skippedActivation(after, t); ok {
+		return skipped
+	}
+	for repeatedWallClock(t) {
+		t = s.cron.Next(t)
+	}
+	return t
+}
+
+// skippedActivation checks whether clocks went forward between after and t,
+// and the schedule would have run during the skipped wall-clock interval.  If
+// so, it returns the moment clocks changed.
+func (s schedule) skippedActivation(after, t time.Time) (time.Time, bool) {
+	_, before := after.Zone()
+	_, later := t.Zone()
+	if later <= before {
+		return time.Time{}, false
+	}
+
+	// Find the first instant with the new offset.
+	lo, hi := after, t
+	for hi.Sub(lo) > time.Second {
+		mid := lo.Add(hi.Sub(lo) / 2)
+		if _, offset := mid.Zone(); offset == before {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	change := hi.Truncate(time.Second)
+	if !change.After(after) {
+		return time.Time{}, false
+	}
+
+	// Evaluate the schedule on the old offset's wall clock, where the skipped
+	// times still exist.
+	old := time.FixedZone("", before)
+	gapStart := change.In(old)
+	gapEnd := gapStart.Add(time.Duration(later-before) * time.Second)
+	if candidate := s.cron.Next(gapStart.Add(-time.Second)); candidate.Before(gapEnd) {
+		return change, true
+	}
+	return time.Time{}, false
+}
+
+// repeatedWallClock returns true if t's wall-clock time already occurred
+// earlier the same day, because clocks went back in between.
+func repeatedWallClock(t time.Time) bool {
+	_, now := t.Zone()
+	_, earlier := t.Add(-24 * time.Hour).Zone()
+	if earlier <= now {
+		return false
+	}
+	first := t.Add(-time.Duration(earlier-now) * time.Second)
+	return first.Hour() == t.Hour() && first.Minute() == t.Minute() && first.Day() == t.Day()
+}
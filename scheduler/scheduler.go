@@ -0,0 +1,389 @@
+// Package scheduler applies backup reserve, Storm Watch and operation mode
+// changes on cron schedules.
+//
+// Schedules are evaluated in the site's installation time zone (from
+// site_info) unless another location is given, and follow traditional cron
+// daylight saving rules: an entry whose time is skipped when clocks go forward
+// runs at the moment they change, and one whose time repeats when clocks go
+// back only runs once.  At startup the scheduler works out
+// which entry most recently applied to each setting and reconciles the site
+// with it, so a restart or a missed activation never leaves the site in the
+// wrong state:
+//
+//	s, err := scheduler.New(client, []scheduler.Entry{
+//		{Name: "peak", Cron: "0 16 * * 1-5", BackupReserve: &eighty},
+//		{Name: "off-peak", Cron: "0 21 * * *", BackupReserve: &twenty},
+//	}, scheduler.WithChangeHandler(func(c scheduler.Change) { log.Println(c) }))
+//
+//	s.Run(ctx)
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/blampe/powerwall"
+)
+
+// lookbackWindows are the periods searched, in turn, for the most recent
+// activation of an entry when reconciling.
+var lookbackWindows = []time.Duration{
+	time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+	32 * 24 * time.Hour,
+	367 * 24 * time.Hour,
+}
+
+// Settings which an Entry can change.
+const (
+	SettingBackupReserve = "backup_reserve_percent"
+	SettingStormMode     = "storm_mode"
+	SettingOperationMode = "operation_mode"
+)
+
+// Entry is a set of changes applied whenever its cron expression matches.
+// Fields left unset are not changed.
+type Entry struct {
+	Name          string `yaml:"name"`
+	Cron          string `yaml:"cron"`
+	BackupReserve *int   `yaml:"backup_reserve_percent"`
+	StormMode     *bool  `yaml:"storm_mode"`
+	OperationMode string `yaml:"operation_mode"`
+}
+
+// Change records a setting applied by the scheduler.  Err is set if the API
+// call failed.
+type Change struct {
+	Entry     string
+	Scheduled time.Time // Activation time, or the reconcile time at startup
+	Reconcile bool      // True if applied while reconciling at startup
+	Setting   string    // One of the Setting* constants
+	Value     string
+	Err       error
+}
+
+func (c Change) String() string {
+	source := "schedule"
+	if c.Reconcile {
+		source = "reconcile"
+	}
+	s := fmt.Sprintf("%s: set %s to %s (%s at %s)", c.Entry, c.Setting, c.Value, source, c.Scheduled.Format(time.RFC3339))
+	if c.Err != nil {
+		s += ": " + c.Err.Error()
+	}
+	return s
+}
+
+// Scheduler applies entries to the client's selected energy site.
+type Scheduler struct {
+	client        *powerwall.Client
+	entries       []Entry
+	schedules     []schedule
+	location      *time.Location
+	changeHandler func(Change)
+	errHandler    func(error)
+}
+
+// New creates a Scheduler for the client's currently selected energy site.
+// Entries are validated and their cron expressions parsed up front.
+func New(client *powerwall.Client, entries []Entry, options ...func(s *Scheduler)) (*Scheduler, error) {
+	s := &Scheduler{
+		client:        client,
+		entries:       append([]Entry(nil), entries...),
+		changeHandler: func(Change) {},
+		errHandler:    func(error) {},
+	}
+
+	// Apply options
+	for _, option := range options {
+		if option != nil {
+			option(s)
+		}
+	}
+
+	for i, entry := range s.entries {
+		if entry.Name == "" {
+			s.entries[i].Name = fmt.Sprintf("entry %d", i+1)
+		}
+		parsed, err := parseSchedule(entry.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s: invalid cron expression %q: %w", s.entries[i].Name, entry.Cron, err)
+		}
+		if entry.BackupReserve == nil && entry.StormMode == nil && entry.OperationMode == "" {
+			return nil, fmt.Errorf("schedule %s: no settings to change", s.entries[i].Name)
+		}
+		if entry.BackupReserve != nil && (*entry.BackupReserve < 0 || *entry.BackupReserve > 100) {
+			return nil, fmt.Errorf("schedule %s: backup reserve must be between 0 and 100, got %d", s.entries[i].Name, *entry.BackupReserve)
+		}
+		switch entry.OperationMode {
+		case "", powerwall.OperationModeSelfConsumption, powerwall.OperationModeAutonomous, powerwall.OperationModeBackup:
+		default:
+			return nil, fmt.Errorf("schedule %s: invalid operation mode: %s", s.entries[i].Name, entry.OperationMode)
+		}
+		s.schedules = append(s.schedules, parsed)
+	}
+
+	return s, nil
+}
+
+// WithLocation evaluates schedules in loc instead of the site's installation
+// time zone
+func WithLocation(loc *time.Location) func(s *Scheduler) {
+	return func(s *Scheduler) {
+		s.location = loc
+	}
+}
+
+// WithChangeHandler registers a callback which is called for every setting
+// the scheduler applies, successfully or not
+func WithChangeHandler(f func(Change)) func(s *Scheduler) {
+	return func(s *Scheduler) {
+		s.changeHandler = f
+	}
+}
+
+// WithErrorHandler registers a callback for failed reconciliations and
+// schedule entries which couldn't be applied.  A failed entry isn't retried
+// until its next activation.
+func WithErrorHandler(f func(error)) func(s *Scheduler) {
+	return func(s *Scheduler) {
+		s.errHandler = f
+	}
+}
+
+// Location returns the time zone schedules are evaluated in, looking up the
+// site's installation time zone the first time it is needed.
+func (s *Scheduler) Location() (*time.Location, error) {
+	return s.LocationContext(context.Background())
+}
+
+// LocationContext is like Location but uses ctx for cancellation and tracing.
+func (s *Scheduler) LocationContext(ctx context.Context) (*time.Location, error) {
+	if s.location != nil {
+		return s.location, nil
+	}
+
+	info, err := s.client.GetSiteInfoContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if info.TimeZone == "" {
+		return nil, fmt.Errorf("energy site %d has no installation time zone", s.client.GetSelectedEnergySite())
+	}
+	loc, err := time.LoadLocation(info.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("loading installation time zone: %w", err)
+	}
+	s.location = loc
+	return loc, nil
+}
+
+// Run reconciles the site with the schedule and then applies each entry as it
+// comes due, until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) error {
+	loc, err := s.LocationContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.ReconcileContext(ctx); err != nil {
+		s.errHandler(fmt.Errorf("reconciling schedule: %w", err))
+	}
+
+	last := time.Now()
+	for {
+		next, due := s.nextActivation(last, loc)
+		if next.IsZero() {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		for _, i := range due {
+			if err := s.apply(ctx, s.entries[i], s.entries[i].settings(), next, false); err != nil {
+				s.errHandler(fmt.Errorf("schedule %s: %w", s.entries[i].Name, err))
+			}
+		}
+		last = next
+	}
+}
+
+// nextActivation returns the earliest activation after the given time and
+// the indices of the entries due then.
+func (s *Scheduler) nextActivation(after time.Time, loc *time.Location) (time.Time, []int) {
+	var next time.Time
+	var due []int
+	for i, sched := range s.schedules {
+		t := sched.next(after, loc)
+		switch {
+		case t.IsZero():
+		case next.IsZero() || t.Before(next):
+			next, due = t, []int{i}
+		case t.Equal(next):
+			due = append(due, i)
+		}
+	}
+	return next, due
+}
+
+// Reconcile applies, for each setting, the value from the entry which most
+// recently activated.  Backup reserve and operation mode are only changed if
+// the site doesn't already match.  Entries which haven't activated in the
+// past year are ignored.
+func (s *Scheduler) Reconcile() error {
+	return s.ReconcileContext(context.Background())
+}
+
+// ReconcileContext is like Reconcile but uses ctx for cancellation and
+// tracing.
+func (s *Scheduler) ReconcileContext(ctx context.Context) error {
+	loc, err := s.LocationContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	latest := map[string]int{}
+	latestTime := map[string]time.Time{}
+	for i, entry := range s.entries {
+		prev := s.previousActivation(s.schedules[i], now, loc)
+		if prev.IsZero() {
+			continue
+		}
+		for _, setting := range entry.settings() {
+			// Later entries win ties, matching the order they'd be applied in
+			if t, ok := latestTime[setting]; !ok || !prev.Before(t) {
+				latest[setting] = i
+				latestTime[setting] = prev
+			}
+		}
+	}
+
+	var firstErr error
+	for _, setting := range []string{SettingOperationMode, SettingBackupReserve, SettingStormMode} {
+		i, ok := latest[setting]
+		if !ok {
+			continue
+		}
+		entry := s.entries[i]
+
+		current, err := s.current(ctx, setting)
+		if err != nil {
+			s.errHandler(fmt.Errorf("reading current %s: %w", setting, err))
+		} else if current != "" && current == entry.value(setting) {
+			continue
+		}
+
+		if err := s.apply(ctx, entry, []string{setting}, now, true); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// previousActivation returns the most recent activation at or before now, or
+// the zero time if there wasn't one within the lookback windows.
+func (s *Scheduler) previousActivation(sched schedule, now time.Time, loc *time.Location) time.Time {
+	for _, window := range lookbackWindows {
+		var prev time.Time
+		for t := sched.next(now.Add(-window), loc); !t.IsZero() && !t.After(now); t = sched.next(t, loc) {
+			prev = t
+		}
+		if !prev.IsZero() {
+			return prev
+		}
+	}
+	return time.Time{}
+}
+
+// current returns the site's current value of a setting, or "" if it can't be
+// read.
+func (s *Scheduler) current(ctx context.Context, setting string) (string, error) {
+	switch setting {
+	case SettingBackupReserve:
+		reserve, err := s.client.GetBackupReserveContext(ctx)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(reserve), nil
+	case SettingOperationMode:
+		return s.client.GetOperationModeContext(ctx)
+	case SettingStormMode:
+		enabled, err := s.client.GetStormModeContext(ctx)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatBool(enabled), nil
+	default:
+		return "", nil
+	}
+}
+
+// apply sets the given settings from an entry, reporting each one to the
+// change handler.
+func (s *Scheduler) apply(ctx context.Context, entry Entry, settings []string, at time.Time, reconcile bool) error {
+	var firstErr error
+	for _, setting := range settings {
+		var err error
+		switch setting {
+		case SettingOperationMode:
+			err = s.client.SetOperationModeContext(ctx, entry.OperationMode)
+		case SettingBackupReserve:
+			err = s.client.SetBackupReserveContext(ctx, *entry.BackupReserve)
+		case SettingStormMode:
+			err = s.client.SetStormModeContext(ctx, *entry.StormMode)
+		}
+
+		s.changeHandler(Change{
+			Entry:     entry.Name,
+			Scheduled: at,
+			Reconcile: reconcile,
+			Setting:   setting,
+			Value:     entry.value(setting),
+			Err:       err,
+		})
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// settings lists the settings changed by the entry, in the order they are
+// applied.
+func (e Entry) settings() []string {
+	var settings []string
+	if e.OperationMode != "" {
+		settings = append(settings, SettingOperationMode)
+	}
+	if e.BackupReserve != nil {
+		settings = append(settings, SettingBackupReserve)
+	}
+	if e.StormMode != nil {
+		settings = append(settings, SettingStormMode)
+	}
+	return settings
+}
+
+// value returns the entry's value for a setting as a string.
+func (e Entry) value(setting string) string {
+	switch setting {
+	case SettingOperationMode:
+		return e.OperationMode
+	case SettingBackupReserve:
+		return strconv.Itoa(*e.BackupReserve)
+	case SettingStormMode:
+		return strconv.FormatBool(*e.StormMode)
+	}
+	return ""
+}
@@ -0,0 +1,63 @@
+// OpenTelemetry tracing
+//
+//	WithTracerProvider(tp) - Trace requests, rate-limit waits and token refreshes
+//
+// Fleet API requests, rate-limit waits and token refreshes are traced with the
+// global TracerProvider unless WithTracerProvider sets another.  Spans are
+// children of the span in the context passed to the ...Context methods.
+
+package powerwall
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the client's spans.
+const tracerName = "github.com/blampe/powerwall"
+
+// WithTracerProvider traces Fleet API requests with tp instead of the global
+// TracerProvider.
+func WithTracerProvider(tp trace.TracerProvider) func(c *Client) {
+	return func(c *Client) {
+		c.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// startSpan starts an internal span.
+func (c *Client) startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return c.tracer.Start(ctx, name)
+}
+
+// startRequestSpan starts the client span of a Fleet API request.  The span
+// is named after the endpoint template, so that the site ID doesn't end up in
+// span names or attributes.
+func (c *Client) startRequestSpan(ctx context.Context, method, endpoint string) (context.Context, trace.Span) {
+	template := endpointTemplate(endpoint)
+	return c.tracer.Start(ctx, method+" "+template,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", method),
+			attribute.String("url.template", template),
+		))
+}
+
+// endRequestSpan records the outcome of a Fleet API request on its span.
+func endRequestSpan(span trace.Span, statusCode, retries int) {
+	if statusCode != 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+	}
+	span.SetAttributes(attribute.Int("powerwall.retries", retries))
+}
+
+// endSpan records err, if any, and ends span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -381,74 +381,145 @@ type ProductsResponse struct {
 	Count    int             `json:"count"`
 }
 
+// LiveStatus contains the real-time data returned by the Fleet API live_status
+// endpoint.
+//
+// This structure is returned by the GetLiveStatus function.
+type LiveStatus struct {
+	SolarPower         *float64  `json:"solar_power"`
+	BatteryPower       *float64  `json:"battery_power"`
+	LoadPower          *float64  `json:"load_power"`
+	GridPower          *float64  `json:"grid_power"`
+	EnergyLeft         *float64  `json:"energy_left"`
+	TotalPackEnergy    *float64  `json:"total_pack_energy"`
+	PercentageCharged  *float64  `json:"percentage_charged"`
+	GridStatus         string    `json:"grid_status"`
+	IslandStatus       string    `json:"island_status"`
+	StormModeActive    bool      `json:"storm_mode_active"`
+	GridServicesActive bool      `json:"grid_services_active"`
+	Timestamp          time.Time `json:"timestamp"`
+	// Power flow data (matches existing meters/aggregates structure)
+	Site    *MeterAggregatesData `json:"site,omitempty"`
+	Solar   *MeterAggregatesData `json:"solar,omitempty"`
+	Battery *MeterAggregatesData `json:"battery,omitempty"`
+	Load    *MeterAggregatesData `json:"load,omitempty"`
+}
+
 // LiveStatusResponse represents the response from the Fleet API live_status endpoint
 type LiveStatusResponse struct {
-	Response struct {
-		SolarPower         *float64  `json:"solar_power"`
-		BatteryPower       *float64  `json:"battery_power"`
-		LoadPower          *float64  `json:"load_power"`
-		GridPower          *float64  `json:"grid_power"`
-		EnergyLeft         *float64  `json:"energy_left"`
-		TotalPackEnergy    *float64  `json:"total_pack_energy"`
-		PercentageCharged  *float64  `json:"percentage_charged"`
-		GridStatus         string    `json:"grid_status"`
-		IslandStatus       string    `json:"island_status"`
-		StormModeActive    bool      `json:"storm_mode_active"`
-		GridServicesActive bool      `json:"grid_services_active"`
-		Timestamp          time.Time `json:"timestamp"`
-		// Power flow data (matches existing meters/aggregates structure)
-		Site    *MeterAggregatesData `json:"site,omitempty"`
-		Solar   *MeterAggregatesData `json:"solar,omitempty"`
-		Battery *MeterAggregatesData `json:"battery,omitempty"`
-		Load    *MeterAggregatesData `json:"load,omitempty"`
-	} `json:"response"`
+	Response LiveStatus `json:"response"`
 }
 
+// Operation modes accepted by SetOperationMode and reported in the
+// default_real_mode field of site_info.
+const (
+	OperationModeSelfConsumption = "self_consumption" // "Self-Powered" in the Tesla app
+	OperationModeAutonomous      = "autonomous"       // "Time-Based Control" in the Tesla app
+	OperationModeBackup          = "backup"           // "Backup-only" in the Tesla app
+)
+
+// Export rules accepted by SetExportRule and reported in the
+// customer_preferred_export_rule field of site_info.
+const (
+	ExportRuleBatteryOK = "battery_ok" // Export solar and battery energy
+	ExportRulePVOnly    = "pv_only"    // Export solar energy only
+	ExportRuleNever     = "never"      // Don't export
+)
+
 // SiteInfoResponse represents the response from the Fleet API site_info endpoint
 type SiteInfoResponse struct {
+	Response SiteConfig `json:"response"`
+}
+
+// SiteConfig is the configuration of an energy site as reported by the Fleet
+// API site_info endpoint.
+//
+// This structure is returned by the GetSiteConfig function.
+type SiteConfig struct {
+	ID                   string                 `json:"id"`
+	SiteName             string                 `json:"site_name"`
+	BackupReservePercent *int                   `json:"backup_reserve_percent"`
+	DefaultRealMode      string                 `json:"default_real_mode"`
+	InstallationDate     time.Time              `json:"installation_date"`
+	UserSettings         map[string]interface{} `json:"user_settings"`
+	Components           SiteComponents         `json:"components"`
+	Version              string                 `json:"version"`
+	BatteryCount         int                    `json:"battery_count"`
+	TariffContent        map[string]interface{} `json:"tariff_content"`
+	TariffID             string                 `json:"tariff_id"`
+	NameplatePower       int                    `json:"nameplate_power"` // W
+	InstallationTimeZone string                 `json:"installation_time_zone"`
+	MaxSiteMeterPowerAC  int64                  `json:"max_site_meter_power_ac"` // W
+	MinSiteMeterPowerAC  int64                  `json:"min_site_meter_power_ac"` // W
+
+	// VPPBackupReservePercent is the reserve kept while the site takes part
+	// in a virtual power plant event.
+	VPPBackupReservePercent int    `json:"vpp_backup_reserve_percent"`
+	Utility                 string `json:"utility"`
+}
+
+// SiteComponents describes the equipment installed at an energy site and the
+// features it supports.
+type SiteComponents struct {
+	Solar               bool      `json:"solar"`
+	SolarType           string    `json:"solar_type"`
+	Battery             bool      `json:"battery"`
+	Grid                bool      `json:"grid"`
+	Backup              bool      `json:"backup"`
+	Gateway             string    `json:"gateway"`
+	LoadMeter           bool      `json:"load_meter"`
+	TOUCapable          bool      `json:"tou_capable"`
+	StormModeCapable    bool      `json:"storm_mode_capable"`
+	BatteryType         string    `json:"battery_type"`
+	Configurable        bool      `json:"configurable"`
+	GridServicesEnabled bool      `json:"grid_services_enabled"`
+	Gateways            []Gateway `json:"gateways"`
+
+	// CustomerPreferredExportRule is the export rule (one of the ExportRule*
+	// constants), and DisallowChargeFromGridWithSolarInstalled is true if
+	// the battery may not charge from the grid.
+	CustomerPreferredExportRule              string `json:"customer_preferred_export_rule"`
+	DisallowChargeFromGridWithSolarInstalled bool   `json:"disallow_charge_from_grid_with_solar_installed"`
+}
+
+// Gateway is a gateway device at an energy site.
+type Gateway struct {
+	DeviceID        string    `json:"device_id"`
+	DIN             string    `json:"din"`
+	SerialNumber    string    `json:"serial_number"`
+	PartNumber      string    `json:"part_number"`
+	PartType        int       `json:"part_type"`
+	PartName        string    `json:"part_name"`
+	IsActive        bool      `json:"is_active"`
+	SiteID          string    `json:"site_id"`
+	FirmwareVersion string    `json:"firmware_version"`
+	UpdatedDatetime time.Time `json:"updated_datetime"`
+}
+
+// FirmwareVersions is the software running at an energy site.
+//
+// This structure is returned by the GetFirmwareVersions function.
+type FirmwareVersions struct {
+	// Version is the site's software version as reported by site_info.
+	Version  string            `json:"version"`
+	Gateways []GatewayFirmware `json:"gateways"`
+}
+
+// GatewayFirmware is the firmware version of a single gateway.
+type GatewayFirmware struct {
+	DIN             string    `json:"din"`
+	SerialNumber    string    `json:"serial_number"`
+	PartName        string    `json:"part_name"`
+	FirmwareVersion string    `json:"firmware_version"`
+	UpdatedDatetime time.Time `json:"updated_datetime"`
+}
+
+// CommandResponse represents the response from a Fleet API energy site
+// command, e.g. {"response": {"code": 201, "message": "Updated"}}
+type CommandResponse struct {
 	Response struct {
-		ID                   string                 `json:"id"`
-		SiteName             string                 `json:"site_name"`
-		BackupReservePercent *int                   `json:"backup_reserve_percent"`
-		DefaultRealMode      string                 `json:"default_real_mode"`
-		InstallationDate     time.Time              `json:"installation_date"`
-		UserSettings         map[string]interface{} `json:"user_settings"`
-		Components           struct {
-			Solar               bool   `json:"solar"`
-			SolarType           string `json:"solar_type"`
-			Battery             bool   `json:"battery"`
-			Grid                bool   `json:"grid"`
-			Backup              bool   `json:"backup"`
-			Gateway             string `json:"gateway"`
-			LoadMeter           bool   `json:"load_meter"`
-			TOUCapable          bool   `json:"tou_capable"`
-			StormModeCapable    bool   `json:"storm_mode_capable"`
-			BatteryType         string `json:"battery_type"`
-			Configurable        bool   `json:"configurable"`
-			GridServicesEnabled bool   `json:"grid_services_enabled"`
-			Gateways            []struct {
-				DeviceID        string    `json:"device_id"`
-				DIN             string    `json:"din"`
-				SerialNumber    string    `json:"serial_number"`
-				PartNumber      string    `json:"part_number"`
-				PartType        int       `json:"part_type"`
-				PartName        string    `json:"part_name"`
-				IsActive        bool      `json:"is_active"`
-				SiteID          string    `json:"site_id"`
-				FirmwareVersion string    `json:"firmware_version"`
-				UpdatedDatetime time.Time `json:"updated_datetime"`
-			} `json:"gateways"`
-		} `json:"components"`
-		Version                 string                 `json:"version"`
-		BatteryCount            int                    `json:"battery_count"`
-		TariffContent           map[string]interface{} `json:"tariff_content"`
-		TariffID                string                 `json:"tariff_id"`
-		NameplatePower          int                    `json:"nameplate_power"`
-		InstallationTimeZone    string                 `json:"installation_time_zone"`
-		MaxSiteMeterPowerAC     int64                  `json:"max_site_meter_power_ac"`
-		MinSiteMeterPowerAC     int64                  `json:"min_site_meter_power_ac"`
-		VPPBackupReservePercent int                    `json:"vpp_backup_reserve_percent"`
-		Utility                 string                 `json:"utility"`
+		Code    int    `json:"code"`
+		Message string `json:"message"`
 	} `json:"response"`
 }
 
@@ -0,0 +1,219 @@
+// Streaming access to live_status
+//
+//	(*Client) Watch(ctx, interval) - Stream of live_status snapshots
+//	(*Client) WatchEvents(ctx, interval, soeThresholds...) - Stream of change events
+//	DetectEvents(prev, cur, soeThresholds) - Change detection between two snapshots
+
+package powerwall
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// GridStatusActive is the grid_status reported while the site is connected to
+// a working grid.
+const GridStatusActive = "Active"
+
+///////////////////////////////////////////////////////////////////////////////
+// Events
+
+// Event is a change detected between two consecutive live_status snapshots.
+// The concrete type is one of GridStatusChanged, IslandStatusChanged,
+// SOEThresholdCrossed or StormModeChanged.
+type Event interface {
+	// EventTime returns the timestamp of the snapshot which showed the change.
+	EventTime() time.Time
+	String() string
+}
+
+// GridStatusChanged is emitted when the grid_status of a site changes, for
+// example from "Active" to "Inactive" when the grid goes down.
+type GridStatusChanged struct {
+	Time time.Time
+	From string
+	To   string
+}
+
+func (e GridStatusChanged) EventTime() time.Time { return e.Time }
+
+func (e GridStatusChanged) String() string {
+	return fmt.Sprintf("Grid status changed: %s -> %s", e.From, e.To)
+}
+
+// GridDown returns true if the grid went from active to anything else.
+func (e GridStatusChanged) GridDown() bool {
+	return e.From == GridStatusActive && e.To != GridStatusActive
+}
+
+// GridRestored returns true if the grid became active again.
+func (e GridStatusChanged) GridRestored() bool {
+	return e.From != GridStatusActive && e.To == GridStatusActive
+}
+
+// IslandStatusChanged is emitted when the island_status of a site changes,
+// for example when the gateway disconnects from the grid.
+type IslandStatusChanged struct {
+	Time time.Time
+	From string
+	To   string
+}
+
+func (e IslandStatusChanged) EventTime() time.Time { return e.Time }
+
+func (e IslandStatusChanged) String() string {
+	return fmt.Sprintf("Island status changed: %s -> %s", e.From, e.To)
+}
+
+// SOEThresholdCrossed is emitted when the battery state of energy crosses one
+// of the watched thresholds in either direction.
+type SOEThresholdCrossed struct {
+	Time      time.Time
+	Threshold float64
+	Previous  float64
+	Current   float64
+}
+
+func (e SOEThresholdCrossed) EventTime() time.Time { return e.Time }
+
+func (e SOEThresholdCrossed) String() string {
+	direction := "rose above"
+	if e.Falling() {
+		direction = "fell below"
+	}
+	return fmt.Sprintf("Battery charge %s %.0f%% (%.1f%% -> %.1f%%)", direction, e.Threshold, e.Previous, e.Current)
+}
+
+// Falling returns true if the state of energy dropped below the threshold.
+func (e SOEThresholdCrossed) Falling() bool {
+	return e.Current < e.Previous
+}
+
+// StormModeChanged is emitted when Storm Watch becomes active or inactive.
+type StormModeChanged struct {
+	Time   time.Time
+	Active bool
+}
+
+func (e StormModeChanged) EventTime() time.Time { return e.Time }
+
+func (e StormModeChanged) String() string {
+	if e.Active {
+		return "Storm Watch activated"
+	}
+	return "Storm Watch deactivated"
+}
+
+// DetectEvents compares two consecutive snapshots and returns the changes
+// between them.  soeThresholds are percentages; an SOEThresholdCrossed event
+// is returned for each one the state of energy crossed, in either direction.
+// If prev is nil, no events are returned.
+func DetectEvents(prev, cur *LiveStatus, soeThresholds []float64) []Event {
+	if prev == nil || cur == nil {
+		return nil
+	}
+
+	var events []Event
+	if prev.GridStatus != cur.GridStatus {
+		events = append(events, GridStatusChanged{Time: cur.Timestamp, From: prev.GridStatus, To: cur.GridStatus})
+	}
+	if prev.IslandStatus != cur.IslandStatus {
+		events = append(events, IslandStatusChanged{Time: cur.Timestamp, From: prev.IslandStatus, To: cur.IslandStatus})
+	}
+	if prev.StormModeActive != cur.StormModeActive {
+		events = append(events, StormModeChanged{Time: cur.Timestamp, Active: cur.StormModeActive})
+	}
+	if prev.PercentageCharged != nil && cur.PercentageCharged != nil {
+		before, after := *prev.PercentageCharged, *cur.PercentageCharged
+		for _, threshold := range soeThresholds {
+			falling := before >= threshold && after < threshold
+			rising := before < threshold && after >= threshold
+			if falling || rising {
+				events = append(events, SOEThresholdCrossed{
+					Time:      cur.Timestamp,
+					Threshold: threshold,
+					Previous:  before,
+					Current:   after,
+				})
+			}
+		}
+	}
+	return events
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Watch
+
+// Watch polls live_status for the selected energy site every interval until
+// ctx is cancelled, sending each snapshot on the returned channel.  The
+// channel is closed when ctx is cancelled.
+//
+// The channel is unbuffered and the next poll isn't scheduled until the
+// previous snapshot has been received, so a slow consumer slows down polling
+// rather than building up a backlog.  The interval is raised if necessary to
+// stay within the client's rate limit.  Failed polls are reported through the
+// client's logger, or the function registered with SetErrFunc, and otherwise
+// skipped.
+func (c *Client) Watch(ctx context.Context, interval time.Duration) <-chan LiveStatus {
+	ch := make(chan LiveStatus)
+
+	if rpm := c.GetRateLimit(); rpm > 0 && interval < time.Minute/time.Duration(rpm) {
+		interval = time.Minute / time.Duration(rpm)
+	}
+
+	go func() {
+		defer close(ch)
+
+		for {
+			status, err := c.GetLiveStatusContext(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				c.logError("Watch: live_status poll failed", err)
+			} else {
+				select {
+				case ch <- *status:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			timer := time.NewTimer(interval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+	}()
+
+	return ch
+}
+
+// WatchEvents polls like Watch, but only sends the changes detected between
+// consecutive snapshots (see DetectEvents).  The same backpressure applies:
+// polling pauses until every event from the previous poll has been received.
+func (c *Client) WatchEvents(ctx context.Context, interval time.Duration, soeThresholds ...float64) <-chan Event {
+	ch := make(chan Event)
+
+	go func() {
+		defer close(ch)
+
+		var prev *LiveStatus
+		for status := range c.Watch(ctx, interval) {
+			for _, event := range DetectEvents(prev, &status, soeThresholds) {
+				select {
+				case ch <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+			prev = &status
+		}
+	}()
+
+	return ch
+}